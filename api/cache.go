@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go-titlovi/internal/config"
+	"go-titlovi/internal/metrics"
+	"go-titlovi/internal/tracing"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// cacheEntry is a cached response body along with the validators needed to answer conditional requests.
+type cacheEntry struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// searchEntry is a cached set of search results along with the time they were fetched.
+//
+// Search results are cached rather than rendered responses, as those contain URLs signed per request.
+type searchEntry struct {
+	subtitles []searchResult
+	modTime   time.Time
+}
+
+// newCacheEntry creates a cacheEntry for the data, using the current time as the modification time.
+func newCacheEntry(data []byte) *cacheEntry {
+	return newCacheEntryAt(data, currentModTime())
+}
+
+// newCacheEntryAt creates a cacheEntry for the data with the provided modification time.
+func newCacheEntryAt(data []byte, modTime time.Time) *cacheEntry {
+	return &cacheEntry{
+		data:    data,
+		etag:    computeETag(data),
+		modTime: modTime,
+	}
+}
+
+// newSearchEntry creates a searchEntry for the results, using the current time as the modification time.
+func newSearchEntry(subtitles []searchResult) *searchEntry {
+	return &searchEntry{
+		subtitles: subtitles,
+		modTime:   currentModTime(),
+	}
+}
+
+// currentModTime returns the current time in a form suitable for Last-Modified.
+func currentModTime() time.Time {
+	return time.Now().UTC().Truncate(time.Second) // HTTP dates only have a precision of seconds.
+}
+
+// isFresh reports whether a cached value with the provided modification time is still fresh, given the TTL.
+//
+// Values are kept in the cache for a while after they stop being fresh, so they can be served
+// when Titlovi.com is unavailable.
+func isFresh(modTime time.Time, ttl time.Duration) bool {
+	return time.Since(modTime) < ttl
+}
+
+// getCached looks the key up in the named cache, recording the lookup as a span.
+func getCached(ctx context.Context, cache *ristretto.Cache, name, key string) (any, bool) {
+	_, span := tracing.Start(ctx, "cache.get", tracing.KindInternal, "cache.name", name)
+	defer span.End()
+
+	val, found := cache.Get(key)
+	span.SetAttributes("cache.found", found)
+	return val, found
+}
+
+// setCacheStatus sets the Cache-Status header and counts the lookup toward the named cache, also recording
+// the status on the current span.
+func setCacheStatus(ctx context.Context, w http.ResponseWriter, cache, status string) {
+	w.Header().Set(config.CacheHeader, status)
+	metrics.CacheLookups.Inc(cache, strings.ToLower(status))
+	tracing.SpanFromContext(ctx).SetAttributes("cache."+cache+".status", strings.ToLower(status))
+}
+
+// computeETag returns a strong ETag derived from the SHA-256 hash of the data.
+func computeETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:16]))
+}
+
+// serveCacheEntry writes the entry to the response with the given cache control policy.
+//
+// http.ServeContent takes care of evaluating If-None-Match and If-Modified-Since and responding
+// with 304 Not Modified where appropriate.
+func serveCacheEntry(w http.ResponseWriter, r *http.Request, name string, cacheControl string, entry *cacheEntry) {
+	w.Header().Set("ETag", entry.etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, name, entry.modTime, bytes.NewReader(entry.data))
+}
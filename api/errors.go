@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"go-titlovi/internal/titlovi"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// statusForError maps an error returned while handling a request to the status to respond with.
+func statusForError(err error) int {
+	switch titlovi.KindOf(err) {
+	case titlovi.KindAuth:
+		return http.StatusUnauthorized
+	case titlovi.KindNotFound:
+		return http.StatusNotFound
+	case titlovi.KindRateLimited:
+		return http.StatusTooManyRequests
+	case titlovi.KindUnavailable:
+		return http.StatusServiceUnavailable
+	case titlovi.KindUpstream, titlovi.KindDecode, titlovi.KindBadRequest:
+		return http.StatusBadGateway
+	case titlovi.KindNetwork:
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError responds with the status matching the error, passing on how long Titlovi.com asked us to wait if it did.
+func writeError(w http.ResponseWriter, err error) {
+	status := statusForError(err)
+
+	if after := titlovi.RetryAfterOf(err); after > 0 && (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+	}
+
+	w.WriteHeader(status)
+}
@@ -1,17 +1,28 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"go-titlovi/api/middleware"
 	"go-titlovi/internal/config"
-	"go-titlovi/internal/logger"
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/idmap"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/metadata"
+	"go-titlovi/internal/metrics"
+	"go-titlovi/internal/signing"
 	"go-titlovi/internal/stremio"
 	"go-titlovi/internal/titlovi"
+	"go-titlovi/internal/tracing"
+	"go-titlovi/internal/userconfig"
 	"go-titlovi/web"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
@@ -21,28 +32,165 @@ import (
 
 // BuildRouter builds a new router with handler functions to handle all necessary routes and
 // also appends middleware.
-func BuildRouter(client *titlovi.Client, cache *ristretto.Cache) http.Handler {
+//
+// The configuration store provides the public base URL of the addon, used to build the URLs subtitles are
+// served from, along with the rate limiting policies, cache and signing settings. Handlers read it on every
+// request, so that reloaded settings apply right away. Health checks are served according to health.
+//
+// The routes of the Stremio addon protocol are generated from the manifest, which is assembled from the build
+// and the configuration and validated. It declares the ID prefixes of every scheme the ID mapping can resolve,
+// or the metadata catalog has titles for. It is described in the locale of the user, as is the configuration
+// page.
+//
+// Items are searched for by title when they have no subtitles linked to their IMDb IDs, using the metadata
+// in the catalog or the file names Stremio passes.
+func BuildRouter(store *config.Store, client *titlovi.Client, cache *ristretto.Cache, signer *signing.Signer, limiter *middleware.RateLimiter, health *Health, ids *idmap.Mapping, meta *metadata.Catalog, assets *web.Assets, build string) (http.Handler, error) {
 	r := mux.NewRouter()
 
-	r.Handle("/", http.HandlerFunc(homeHandler()))
+	schemes := ids.Schemes()
+	if store.Current().TitleSearch.Enabled {
+		for _, s := range meta.Schemes() {
+			if !slices.Contains(schemes, s) {
+				schemes = append(schemes, s)
+			}
+		}
+	}
+	manifests, err := assembleManifests(store.Current(), build, schemes)
+	if err != nil {
+		return nil, err
+	}
+	manifest := manifests[false][i18n.Fallback]
+	slog.Info("BuildRouter: assembled manifest", "version", manifest.Version, "name", manifest.Name, "idPrefixes", manifest.IdPrefixes)
+
+	// The manifests describe the languages searched in, which can change on reload.
+	var current atomic.Pointer[manifestSet]
+	current.Store(&manifests)
+	store.OnReload(func(cfg *config.Config) {
+		manifests, err := assembleManifests(cfg, build, schemes)
+		if err != nil {
+			slog.Error("BuildRouter: failed to assemble manifests on reload, keeping the current ones", "error", err)
+			return
+		}
+		current.Store(&manifests)
+	})
 
-	r.Handle("/manifest.json", http.HandlerFunc(manifestHandler()))
-	r.Handle("/{userConfig}/manifest.json", middleware.WithAuth(http.HandlerFunc(manifestHandler())))
+	addon, err := stremio.NewBuilder(manifest).
+		ConfiguredManifest(manifests[true][i18n.Fallback]).
+		AdaptManifest(func(r *http.Request, m stremio.Manifest) stremio.Manifest {
+			return (*current.Load())[!m.BehaviourHints.ConfigurationRequired][middleware.Locale(r.Context())]
+		}).
+		Subtitles(subtitlesHandler(store, client, cache, signer, ids, meta)).
+		ManifestCacheControl(config.ManifestCacheControl).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := rateLimitPolicies(store.Current())
+	defaultLimit := limiter.Limit(policies[0])
+	searchLimit := limiter.Limit(policies[1])
+	serveLimit := limiter.Limit(policies[2])
+	configureLimit := limiter.Limit(policies[3])
+
+	store.OnReload(func(cfg *config.Config) {
+		for _, policy := range rateLimitPolicies(cfg) {
+			limiter.SetPolicy(policy)
+		}
+	})
+
+	r.Handle("/", defaultLimit(http.HandlerFunc(homeHandler())))
+	r.Handle("/status", defaultLimit(http.HandlerFunc(statusHandler(client))))
+	r.Handle("/healthz", http.HandlerFunc(livenessHandler(health)))
+	r.Handle("/readyz", http.HandlerFunc(readinessHandler(health)))
+
+	secure := strings.HasPrefix(store.Current().Server.Address, "https://")
+	locale := middleware.WithLocale(secure)
+
+	addon.Register(r, func(route stremio.Route) http.Handler {
+		var h http.Handler
+		switch route.Resource {
+		case stremio.ResourceManifest:
+			h = defaultLimit(locale(route.Handler))
+		case stremio.ResourceSubtitles:
+			h = searchLimit(route.Handler)
+		default:
+			h = defaultLimit(route.Handler)
+		}
+		if route.Configured {
+			h = middleware.WithAuth(h)
+		}
+		return h
+	})
 
-	r.Handle("/{userConfig}/subtitles/{type}/{id}/{extraArgs}.json", middleware.WithAuth(http.HandlerFunc(subtitlesHandler(client, cache))))
-	r.Handle("/serve-subtitle/{type}/{mediaid}", http.HandlerFunc(serveSubtitleHandler(client, cache)))
+	r.Handle("/serve-subtitle/{type}/{mediaid}", serveLimit(middleware.WithSignature(signer)(http.HandlerFunc(serveSubtitleHandler(store, client, cache)))))
 
-	r.Handle("/configure", http.HandlerFunc(configureHandler()))
-	r.Handle("/{userConfig}/configure", middleware.WithAuth(http.HandlerFunc(configureHandler())))
+	csrf := middleware.WithCSRF(signer, secure)
+	r.Handle("/configure", configureLimit(locale(csrf(http.HandlerFunc(configureHandler(store, assets))))))
+	// The configure policy is not per user, so requests are limited before their configuration is decoded.
+	r.Handle("/{userConfig}/configure", configureLimit(middleware.WithEditableConfig(locale(csrf(http.HandlerFunc(configureHandler(store, assets)))))))
 
+	r.Handle("/static/{path:.+}", http.HandlerFunc(staticHandler(assets))).Methods(http.MethodGet, http.MethodHead)
+
+	adminToken := func() string { return store.Current().Server.AdminToken }
+	r.Handle("/admin/reload", middleware.WithAdminToken(adminToken)(http.HandlerFunc(reloadHandler(store)))).Methods(http.MethodPost)
+
+	r.Use(middleware.WithTracing)
 	r.Use(middleware.WithLogging)
-	r.Use(middleware.WithRateLimit)
+	r.Use(middleware.WithMetrics)
+	r.Use(middleware.WithSecurityHeaders)
+
+	return r, nil
+}
+
+// manifestSet holds the manifest in every locale, keyed by whether the user has configured the addon.
+type manifestSet map[bool]map[string]stremio.Manifest
+
+// assembleManifests assembles and validates the manifests in every locale, for users who have configured the
+// addon and for those who have not.
+func assembleManifests(cfg *config.Config, build string, schemes []stremio.IDScheme) (manifestSet, error) {
+	manifests := make(manifestSet, 2)
+	for _, configured := range []bool{false, true} {
+		manifests[configured] = make(map[string]stremio.Manifest, len(i18n.Locales))
+		for _, l := range i18n.Locales {
+			m := config.NewManifest(cfg, config.ManifestInfo{Build: build, Schemes: schemes, Configured: configured, Locale: l.Code})
+			if err := m.Validate(); err != nil {
+				return nil, fmt.Errorf("manifest in %s: %w", l.Code, err)
+			}
+			manifests[configured][l.Code] = m
+		}
+	}
+	return manifests, nil
+}
 
-	return r
+// rateLimitPolicies returns the default, search, serve and configure policies, in that order.
+func rateLimitPolicies(cfg *config.Config) []middleware.RateLimitPolicy {
+	return []middleware.RateLimitPolicy{
+		{
+			Name:  "default",
+			Rate:  cfg.RateLimit.Default.Rate,
+			Burst: cfg.RateLimit.Default.Burst,
+		},
+		{
+			Name:    "search",
+			Rate:    cfg.RateLimit.Search.Rate,
+			Burst:   cfg.RateLimit.Search.Burst,
+			PerUser: true,
+		},
+		{
+			Name:  "serve",
+			Rate:  cfg.RateLimit.Serve.Rate,
+			Burst: cfg.RateLimit.Serve.Burst,
+		},
+		{
+			Name:  "configure",
+			Rate:  cfg.RateLimit.Configure.Rate,
+			Burst: cfg.RateLimit.Configure.Burst,
+		},
+	}
 }
 
-// BuildServer builds an http.Server with settings and CORS pre-configured.
-func BuildServer(r *http.Handler) *http.Server {
+// BuildServer builds an http.Server with settings from the configuration and CORS pre-configured.
+func BuildServer(cfg *config.Config, r *http.Handler) *http.Server {
 
 	// CORS configuration
 	headersOk := handlers.AllowedHeaders([]string{
@@ -58,247 +206,389 @@ func BuildServer(r *http.Handler) *http.Server {
 	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD"})
 
 	server := &http.Server{
-		Addr:         fmt.Sprintf("0.0.0.0:%s", config.Port),
+		Addr:         fmt.Sprintf("0.0.0.0:%s", cfg.Server.Port),
 		Handler:      handlers.CORS(originsOk, headersOk, methodsOk)(*r),
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
 	return server
 }
 
-// homeHandler handles requests to the root and provides a dummy response.
-func homeHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		jsonResponse, err := json.Marshal(map[string]any{"path": "/"})
-		if err != nil {
-			logger.LogError.Printf("homeHandler: failed to marshal json: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(jsonResponse)
+// BuildMetricsServer builds the server exposing Prometheus metrics at /metrics on the metrics port,
+// which is kept apart from the addon so that metrics are not public.
+func BuildMetricsServer(cfg *config.Config) *http.Server {
+	r := http.NewServeMux()
+	r.Handle("/metrics", metrics.Default.Handler())
+
+	return &http.Server{
+		Addr:         fmt.Sprintf("0.0.0.0:%s", cfg.Server.MetricsPort),
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 }
 
-// manifestHandler handles requests for the Stremio manifest.
-func manifestHandler() http.HandlerFunc {
+// homeHandler handles requests to the root by sending visitors to the configuration page.
+func homeHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		manifest := config.Manifest
-
-		userConfig := r.Context().Value(middleware.UserConfigContextKey).(*stremio.UserConfig)
-		manifest.BehaviourHints.ConfigurationRequired = userConfig == nil
-
-		jsonResponse, err := json.Marshal(manifest)
-		if err != nil {
-			logger.LogError.Printf("manifestHandler: failed to marshal json: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(jsonResponse)
+		http.Redirect(w, r, "/configure", http.StatusFound)
 	}
 }
 
 // subtitlesHandler handles requests for Titlovi.com search results.
-func subtitlesHandler(client *titlovi.Client, cache *ristretto.Cache) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
+//
+// IDs other than IMDb IDs are resolved through the ID mapping. Items that cannot be resolved, or that have no
+// subtitles linked to their IMDb IDs, are searched for by title if title search is enabled and their title is known.
+func subtitlesHandler(store *config.Store, client *titlovi.Client, cache *ristretto.Cache, signer *signing.Signer, ids *idmap.Mapping, meta *metadata.Catalog) stremio.Handler[stremio.SubtitlesExtra] {
+	return func(w http.ResponseWriter, r *http.Request, args stremio.Args[stremio.SubtitlesExtra]) {
+		cfg := store.Current()
 		ctx := r.Context()
 
-		userConfig := r.Context().Value(middleware.UserConfigContextKey).(*stremio.UserConfig)
-		if userConfig == nil {
-			logger.LogError.Printf("subtitlesHandler: user config was nil")
+		userConfig, ok := r.Context().Value(middleware.UserConfigContextKey).(*userconfig.Config)
+		if !ok || userConfig == nil {
+			slog.ErrorContext(ctx, "subtitlesHandler: user config was nil")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		_, ok := params["type"]
-		if !ok {
-			logger.LogError.Printf("subtitlesHandler: failed to get 'type' from path")
-			w.WriteHeader(http.StatusBadRequest)
+		videoID, err := stremio.ParseVideoID(args.ID)
+		if err != nil {
+			slog.InfoContext(ctx, "subtitlesHandler: malformed video ID", "error", err)
+			http.Error(w, "malformed video ID", http.StatusBadRequest)
 			return
 		}
 
-		id, ok := params["id"]
-		if !ok {
-			logger.LogError.Printf("subtitlesHandler: failed to get 'id' from path")
-			w.WriteHeader(http.StatusBadRequest)
-			return
+		req := searchRequest{mediaType: args.Type, season: videoID.Season, episode: videoID.Episode}
+		req.query, req.resolved = ids.Resolve(videoID)
+		if req.resolved {
+			req.season, req.episode = req.query.Season, req.query.Episode
+		}
+		if cfg.TitleSearch.Enabled {
+			req.meta = itemMeta(meta, videoID, args.Extra)
 		}
 
-		resp := &stremio.SubtitlesResponse{
-			Subtitles: []*stremio.SubtitleItem{},
+		if !req.resolved && req.meta == nil {
+			slog.InfoContext(ctx, "subtitlesHandler: no IMDb ID mapped for video ID and no title known", "id", args.ID)
+			writeNoSubtitles(w)
+			return
 		}
 
-		// Serve the results from the cache if found.
-		if val, found := cache.Get(id); found {
-			w.Header().Set(config.CacheHeader, config.CacheHit)
+		// Results are cached by the IMDb item where there is one, so that IDs of every scheme share them.
+		id := videoID.String()
+		if req.resolved {
+			id = req.query.String()
+		}
+		id = req.cacheKey(id)
+		var entry *searchEntry
 
-			resp, ok = val.(*stremio.SubtitlesResponse)
+		if val, found := getCached(ctx, cache, "search", id); found {
+			entry, ok = val.(*searchEntry)
 			if !ok {
-				logger.LogError.Printf("subtitlesHandler: value found in cache was of an unexpected type")
+				slog.ErrorContext(ctx, "subtitlesHandler: value found in cache was of an unexpected type")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-		} else {
-			w.Header().Set(config.CacheHeader, config.CacheMiss)
-			imdbId, season, episode := stremio.ParseVideoId(id)
+		}
 
-			subtitleData, err := client.Search(ctx, imdbId, season, episode, config.TitloviLanguages, userConfig.Username, userConfig.Password)
-			if err != nil {
-				logger.LogError.Printf("subtitlesHandler: failed to search for subtitles: %s", err.Error())
-				w.WriteHeader(http.StatusBadRequest)
+		// Serve the results from the cache if found and still fresh.
+		if entry != nil && isFresh(entry.modTime, cfg.Cache.TTL) {
+			setCacheStatus(ctx, w, "search", config.CacheHit)
+		} else {
+			results, err := searchSubtitles(ctx, client, req, languages.Default.Canonical(cfg.Titlovi.Languages), userConfig.Username, userConfig.Password)
+			switch {
+			case err != nil && entry != nil:
+				// Stale results are better than none while Titlovi.com is unavailable.
+				slog.WarnContext(ctx, "subtitlesHandler: failed to search for subtitles, serving stale results", "error", err)
+				setCacheStatus(ctx, w, "search", config.CacheStale)
+			case err != nil:
+				slog.ErrorContext(ctx, "subtitlesHandler: failed to search for subtitles", "error", err)
+				writeError(w, err)
 				return
-			}
+			default:
+				setCacheStatus(ctx, w, "search", config.CacheMiss)
+				slog.InfoContext(ctx, "subtitlesHandler: got subtitles", "count", len(results), "id", id, "sources", countSources(results))
 
-			// Pre-allocate according to what we got.
-			resp.Subtitles = make([]*stremio.SubtitleItem, len(subtitleData))
-
-			for i, data := range subtitleData {
-				idStr := strconv.Itoa(int(data.Id))
-				servePath := fmt.Sprintf("%s/serve-subtitle/%d/%s", config.ServerAddress, data.Type, idStr)
-				langCode := stremio.GetLangCode(data.Lang)
-				resp.Subtitles[i] = &stremio.SubtitleItem{
-					Id:   idStr,
-					Url:  servePath,
-					Lang: langCode,
-					// Url:  fmt.Sprintf("http://127.0.0.1:11470/subtitles.vtt?from=%s", servePath), // For testing
-					// Lang: fmt.Sprintf("%s|%s", langCode, config.SubtitleSuffix), // For testing
-				}
-				logger.LogInfo.Printf("subtitlesHandler: prepared %+v", *resp.Subtitles[i])
+				// The modification time is recorded here so that it stays stable for as long as the entry is cached.
+				entry = newSearchEntry(results)
+				cache.SetWithTTL(id, entry, 0, cfg.Cache.TTL+cfg.Cache.StaleTTL)
 			}
+		}
 
-			logger.LogInfo.Printf("subtitlesHandler: got %d subtitles for '%s'", len(resp.Subtitles), id)
+		// Serve URLs are signed per request since they can be labelled with the user, while search results are shared.
+		var user string
+		if cfg.Signing.BindUser {
+			user = signer.UserID(userConfig.Username)
+		}
+		now := time.Now()
 
-			cache.SetWithTTL(id, resp, 0, config.CacheTTL)
+		subtitles := applyPreferences(entry.subtitles, userConfig, cfg.Titlovi.Languages)
+		resp := &stremio.SubtitlesResponse{
+			// Pre-allocate according to what we got.
+			Subtitles: make([]*stremio.SubtitleItem, len(subtitles)),
+		}
+
+		for i, data := range subtitles {
+			idStr := strconv.Itoa(int(data.Id))
+			typeStr := strconv.Itoa(int(data.Type))
+			servePath := fmt.Sprintf("%s/serve-subtitle/%s/%s?%s", cfg.Server.Address, typeStr, idStr, signer.Sign(typeStr, idStr, user, now).Encode())
+			lang := languages.Default.Resolve(data.Lang)
+			if !lang.Known() {
+				slog.DebugContext(ctx, "subtitlesHandler: unknown subtitle language", "lang", data.Lang)
+			}
+			resp.Subtitles[i] = &stremio.SubtitleItem{
+				Id:   idStr,
+				Url:  servePath,
+				Lang: lang.Code,
+				// Url:  fmt.Sprintf("http://127.0.0.1:11470/subtitles.vtt?from=%s", url.QueryEscape(servePath)), // For testing
+				// Lang: fmt.Sprintf("%s|%s", lang.Code, config.SubtitleSuffix), // For testing
+			}
 		}
 
 		jsonResponse, err := json.Marshal(resp)
 		if err != nil {
-			logger.LogError.Printf("subtitlesHandler: failed to marshal response: %s", err)
+			slog.ErrorContext(ctx, "subtitlesHandler: failed to marshal response", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		// The response changes whenever the signed URLs roll over to a new window, even if the results did not.
+		modTime := entry.modTime
+		if windowStart := now.Truncate(cfg.Signing.Window).UTC(); windowStart.After(modTime) {
+			modTime = windowStart
+		}
+
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(jsonResponse)
+		serveCacheEntry(w, r, "subtitles.json", config.SubtitlesCacheControl, newCacheEntryAt(jsonResponse, modTime))
 	}
 }
 
+// writeNoSubtitles responds with an empty list of subtitles.
+func writeNoSubtitles(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", config.SubtitlesCacheControl)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"subtitles":[]}`))
+}
+
+// rankSubtitles returns the subtitles ordered by the position of their language in the preferred languages,
+// keeping the order of Titlovi.com within a language. The subtitles are not modified, as they are shared
+// through the cache.
+func rankSubtitles(subtitles []searchResult, preferred []string) []searchResult {
+	ranked := slices.Clone(subtitles)
+	slices.SortStableFunc(ranked, func(a, b searchResult) int {
+		return languages.Default.Rank(a.Lang, preferred) - languages.Default.Rank(b.Lang, preferred)
+	})
+	return ranked
+}
+
 // serveSubtitleHandler handles requests for downloading specific subtitles from Titlovi.com.
-func serveSubtitleHandler(client *titlovi.Client, cache *ristretto.Cache) http.HandlerFunc {
+func serveSubtitleHandler(store *config.Store, client *titlovi.Client, cache *ristretto.Cache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Current()
 		params := mux.Vars(r)
 		ctx := r.Context()
 
 		mediaType, ok := params["type"]
 		if !ok {
-			logger.LogError.Printf("serveSubtitleHandler: failed to get 'type' from path")
+			slog.ErrorContext(ctx, "serveSubtitleHandler: failed to get 'type' from path")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		mediaId, ok := params["mediaid"]
 		if !ok {
-			logger.LogError.Printf("serveSubtitleHandler: failed to get 'mediaid' from path")
+			slog.ErrorContext(ctx, "serveSubtitleHandler: failed to get 'mediaid' from path")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		var subData []byte
+		var entry *cacheEntry
+		cacheKey := fmt.Sprintf("%s-%s", mediaType, mediaId)
 
-		if val, found := cache.Get(fmt.Sprintf("%s-%s", mediaType, mediaId)); found {
-			w.Header().Set(config.CacheHeader, config.CacheHit)
-
-			subData, ok = val.([]byte)
+		if val, found := getCached(ctx, cache, "subtitle", cacheKey); found {
+			entry, ok = val.(*cacheEntry)
 			if !ok {
-				logger.LogError.Printf("subtitlesHandler: value found in cache was of an unexpected type")
+				slog.ErrorContext(ctx, "serveSubtitleHandler: value found in cache was of an unexpected type")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-		} else {
-			w.Header().Set(config.CacheHeader, config.CacheMiss)
+		}
 
-			// We download the subtitle as a blob from Titlovi.com
-			data, err := client.Download(ctx, mediaType, mediaId)
-			if err != nil {
-				logger.LogError.Printf("serveSubtitlesHandler: failed to download subtitle: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
+		if entry != nil && isFresh(entry.modTime, cfg.Cache.TTL) {
+			setCacheStatus(ctx, w, "subtitle", config.CacheHit)
+		} else {
+			subData, err := downloadSubtitle(ctx, client, mediaType, mediaId)
+			switch {
+			case err != nil && entry != nil:
+				// Stale subtitles are better than none while Titlovi.com is unavailable.
+				slog.WarnContext(ctx, "serveSubtitleHandler: failed to get subtitle, serving stale subtitle", "error", err)
+				setCacheStatus(ctx, w, "subtitle", config.CacheStale)
+			case err != nil:
+				slog.ErrorContext(ctx, "serveSubtitleHandler: failed to get subtitle", "error", err)
+				writeError(w, err)
 				return
-			}
+			default:
+				setCacheStatus(ctx, w, "subtitle", config.CacheMiss)
 
-			// Titlovi.com responds with subtitles that are compressed in ZIP files.
-			// We need to open this ZIP file and extract the first found subtitle as a byte blob.
-			subData, err = titlovi.ExtractSubtitleFromZIP(data)
-			if err != nil {
-				logger.LogError.Printf("serveSubtitleHandler: failed to extract subtitle from ZIP: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+				// The modification time is recorded at first download so that conditional requests can hit.
+				entry = newCacheEntry(subData)
+				cache.SetWithTTL(cacheKey, entry, 0, cfg.Cache.TTL+cfg.Cache.StaleTTL)
 			}
+		}
 
-			utf8, err := titlovi.ConvertSubtitleToUTF8(subData)
-			if err != nil {
-				logger.LogError.Printf("serveSubtitleHandler: failed to convert subtitle: %s", err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		slog.InfoContext(ctx, "serveSubtitleHandler: serving subtitle", "type", mediaType, "mediaid", mediaId)
+		serveCacheEntry(w, r, "file.srt", config.ServeCacheControl, entry)
+	}
+}
+
+// downloadSubtitle downloads a subtitle from Titlovi.com and returns it extracted and converted to UTF-8.
+func downloadSubtitle(ctx context.Context, client *titlovi.Client, mediaType, mediaId string) ([]byte, error) {
+	// We download the subtitle as a blob from Titlovi.com
+	data, err := client.Download(ctx, mediaType, mediaId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download subtitle: %w", err)
+	}
 
-			subData = []byte(utf8)
+	// Titlovi.com responds with subtitles that are compressed in ZIP files.
+	// We need to open this ZIP file and extract the first found subtitle as a byte blob.
+	_, span := tracing.Start(ctx, "subtitle.extract", tracing.KindInternal, "archive.size", len(data))
+	subData, err := titlovi.ExtractSubtitleFromZIP(data)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		metrics.ArchiveExtractionFailures.Inc()
+		return nil, fmt.Errorf("failed to extract subtitle from ZIP: %w", err)
+	}
+
+	_, span = tracing.Start(ctx, "subtitle.convert", tracing.KindInternal, "subtitle.size", len(subData))
+	utf8, err := titlovi.ConvertSubtitleToUTF8(subData)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert subtitle: %w", err)
+	}
+
+	return utf8, nil
+}
 
-			cache.SetWithTTL(fmt.Sprintf("%s-%s", mediaType, mediaId), subData, 0, config.CacheTTL)
+// statusHandler handles requests for the state of the circuit breakers toward Titlovi.com.
+func statusHandler(client *titlovi.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse, err := json.Marshal(map[string]any{"breakers": client.BreakerStatus()})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "statusHandler: failed to marshal json", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 
-		logger.LogInfo.Printf("serveSubtitleHandler: serving %s", r.URL.Path)
-		http.ServeContent(w, r, "file.srt", time.Now().UTC(), bytes.NewReader(subData))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(jsonResponse)
 	}
 }
 
-// configureHandler handles requests for addon configuration and redirects to Stremio when done.
-func configureHandler() http.HandlerFunc {
+// reloadHandler handles requests to reload the configuration, responding with what changed.
+func reloadHandler(store *config.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		changes, err := store.Reload()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "reloadHandler: rejected reload", "error", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error": err.Error()})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"changes": changes})
+	}
+}
+
+// configureHandler handles requests for addon configuration and offers the ways to install the addon when done.
+//
+// The form offers the languages subtitles are searched in, according to the configuration store. Requests under
+// an existing configuration edit it, with the form pre-populated from it. The page is shown in the locale picked
+// for the request, which the configuration saves for the manifest to be described in.
+func configureHandler(store *config.Store, assets *web.Assets) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost && r.Method != http.MethodGet {
 			w.WriteHeader(http.StatusNotAcceptable)
 			return
 		}
 
+		w.Header().Set("Cache-Control", config.ConfigureCacheControl)
+
+		cfg := store.Current()
+		searched := cfg.Titlovi.Languages
+		locale := middleware.Locale(r.Context())
+		existing, editing := r.Context().Value(middleware.UserConfigContextKey).(*userconfig.Config)
+
+		page := web.ConfigurePage{
+			Action:      r.URL.Path,
+			CSRFField:   middleware.CSRFField,
+			CSRFToken:   middleware.CSRFToken(r.Context()),
+			Editing:     editing,
+			TitleSearch: cfg.TitleSearch.Enabled,
+			Scripts:     web.Scripts,
+			Rankings:    web.Rankings,
+			Locale:      locale,
+			Locales:     i18n.Locales,
+			LocaleParam: middleware.LocaleParam,
+		}
+
 		if r.Method == http.MethodGet {
-			if err := config.ConfigTemplate.Execute(w, nil); err != nil {
-				logger.LogError.Printf("configureHandler: failed to execute template: %s", err)
-				w.WriteHeader(http.StatusInternalServerError)
+			page.Form = web.NewUserConfig(existing, searched, locale)
+			if editing {
+				if err := userconfig.Validate(existing); err != nil {
+					// Showing the problems lets users fix configurations saved by older versions or edited by hand.
+					slog.InfoContext(r.Context(), "configureHandler: editing invalid user config", "error", err)
+					page.Form.Validate()
+				}
 			}
+			renderConfigurePage(w, r, assets, page)
 			return
 		}
 
-		creds := web.UserConfig{
-			Username: r.FormValue("username"),
-			Password: r.FormValue("password"),
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "malformed form", http.StatusBadRequest)
+			return
+		}
+		page.Form = web.ParseUserConfig(r.PostForm, searched, locale)
+		if editing && page.Form.Password == "" && page.Form.Username == existing.Username {
+			// The password is not shown when editing, so leaving it blank keeps the current one.
+			page.Form.Password = existing.Password
 		}
 
-		if !creds.Validate() {
-			if err := config.ConfigTemplate.Execute(w, creds); err != nil {
-				logger.LogError.Printf("configureHandler: failed to execute template: %s", err)
-				w.WriteHeader(http.StatusInternalServerError)
-			}
+		if !page.Form.Validate() {
+			renderConfigurePage(w, r, assets, page)
 			return
 		}
 
-		enc, err := middleware.EncodeUserConfig(creds)
+		enc, err := userconfig.Encode(page.Form.UserConfig(searched))
 		if err != nil {
-			logger.LogError.Printf("configureHandler: %s", err)
+			slog.ErrorContext(r.Context(), "configureHandler: failed to encode user config", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		redirectUrl := fmt.Sprintf("stremio://%s/%s/manifest.json", r.Host, enc)
+		// Further changes edit the saved configuration.
+		page.Action, page.Editing = "/"+enc+"/configure", true
+		page.Install = web.NewInstallLinks(cfg.Server.Address, enc)
+		renderConfigurePage(w, r, assets, page)
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		http.Redirect(w, r, redirectUrl, http.StatusPermanentRedirect)
+// renderConfigurePage renders the configuration page. The password is never rendered back into the form.
+func renderConfigurePage(w http.ResponseWriter, r *http.Request, assets *web.Assets, page web.ConfigurePage) {
+	page.Form.Password = ""
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := assets.Execute(w, "configuration-form.html", page); err != nil {
+		slog.ErrorContext(r.Context(), "configureHandler: failed to execute template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"go-titlovi/internal/config"
+	"go-titlovi/internal/titlovi"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+const (
+	checkOK       = "ok"
+	checkDegraded = "degraded" // Reported, but does not make the addon not ready.
+	checkFailing  = "failing"
+)
+
+// check is the result of a single readiness check.
+type check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Detail any    `json:"detail,omitempty"`
+}
+
+// probeResult is the result of the last probe of Titlovi.com.
+type probeResult struct {
+	err       error
+	checkedAt time.Time
+	latency   time.Duration
+}
+
+// fresh reports whether the result can still be reused, counting from when the probe finished, so
+// that checks which waited for a slow probe reuse its result instead of probing again.
+func (p *probeResult) fresh(interval time.Duration) bool {
+	return time.Since(p.checkedAt.Add(p.latency)) < interval
+}
+
+// Health tracks whether the addon is ready to serve requests.
+type Health struct {
+	version      string
+	started      time.Time
+	store        *config.Store
+	cache        *ristretto.Cache
+	client       *titlovi.Client
+	shuttingDown atomic.Bool
+
+	probeMtx  sync.Mutex // Held while probing, so that concurrent checks share a single probe.
+	lastProbe *probeResult
+}
+
+// NewHealth creates a Health checking the configuration, cache and client of the build with the version.
+func NewHealth(version string, store *config.Store, cache *ristretto.Cache, client *titlovi.Client) *Health {
+	return &Health{version: version, started: time.Now(), store: store, cache: cache, client: client}
+}
+
+// SetShuttingDown makes readiness fail from now on, so that load balancers stop routing requests here
+// before the server shuts down.
+func (h *Health) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// probe returns the result of the last probe of Titlovi.com, probing again if it is older than the interval.
+//
+// The probe is not bound to the context, which is only used for logging, so that a check cancelled
+// by its caller does not record a failure shared by every other check until the next probe.
+func (h *Health) probe(ctx context.Context, cfg *config.HealthConfig) *probeResult {
+	h.probeMtx.Lock()
+	defer h.probeMtx.Unlock()
+
+	if h.lastProbe != nil && h.lastProbe.fresh(cfg.ProbeInterval) {
+		return h.lastProbe
+	}
+
+	probeCtx, cancel := context.WithTimeout(context.Background(), cfg.ProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.client.Probe(probeCtx)
+	if err != nil {
+		slog.WarnContext(ctx, "probe: Titlovi.com is unreachable", "error", err)
+	}
+
+	h.lastProbe = &probeResult{err: err, checkedAt: start, latency: time.Since(start)}
+	return h.lastProbe
+}
+
+// checks runs every readiness check and reports whether the addon is ready.
+func (h *Health) checks(ctx context.Context) (bool, map[string]check) {
+	checks := make(map[string]check)
+	fail := func(name, msg string) {
+		checks[name] = check{Status: checkFailing, Error: msg}
+	}
+
+	if h.shuttingDown.Load() {
+		fail("shutdown", "shutting down")
+	} else {
+		checks["shutdown"] = check{Status: checkOK}
+	}
+
+	cfg := h.store.Current()
+	if cfg == nil {
+		fail("config", "configuration not loaded")
+		return false, checks
+	}
+	checks["config"] = check{Status: checkOK}
+
+	if h.cache == nil {
+		fail("cache", "cache not initialized")
+	} else {
+		checks["cache"] = check{Status: checkOK}
+	}
+
+	// Upstream problems only make the addon not ready if required, since cached results can still be served.
+	upstreamFailure := checkDegraded
+	if cfg.Health.RequireUpstream {
+		upstreamFailure = checkFailing
+	}
+
+	breakers := h.client.BreakerStatus()
+	breakerCheck := check{Status: checkOK, Detail: breakers}
+	for _, b := range breakers {
+		if b.State == titlovi.BreakerOpen {
+			breakerCheck.Status = upstreamFailure
+			breakerCheck.Error = "circuit breaker open for " + string(b.Endpoint)
+		}
+	}
+	checks["breakers"] = breakerCheck
+
+	if cfg.Health.ProbeUpstream {
+		result := h.probe(ctx, &cfg.Health)
+		detail := map[string]any{
+			"checkedAt": result.checkedAt.UTC().Format(time.RFC3339),
+			"latencyMs": result.latency.Milliseconds(),
+		}
+
+		if result.err != nil {
+			checks["upstream"] = check{Status: upstreamFailure, Error: result.err.Error(), Detail: detail}
+		} else {
+			checks["upstream"] = check{Status: checkOK, Detail: detail}
+		}
+	}
+
+	ready := true
+	for _, c := range checks {
+		if c.Status == checkFailing {
+			ready = false
+		}
+	}
+
+	return ready, checks
+}
+
+// livenessHandler handles requests checking whether the process is alive, which it is if it can respond.
+func livenessHandler(h *Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]any{
+			"status":        checkOK,
+			"version":       h.version,
+			"uptimeSeconds": int64(time.Since(h.started).Seconds()),
+		})
+	}
+}
+
+// readinessHandler handles requests checking whether the addon is ready to serve requests.
+func readinessHandler(h *Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready, checks := h.checks(r.Context())
+
+		status, code := checkOK, http.StatusOK
+		if !ready {
+			status, code = checkFailing, http.StatusServiceUnavailable
+		}
+
+		writeHealth(w, code, map[string]any{"status": status, "checks": checks})
+	}
+}
+
+// writeHealth responds with the health report as JSON.
+func writeHealth(w http.ResponseWriter, code int, report map[string]any) {
+	jsonResponse, err := json.Marshal(report)
+	if err != nil {
+		slog.Error("writeHealth: failed to marshal json", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, _ = w.Write(jsonResponse)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"go-titlovi/internal/config"
+	"go-titlovi/internal/titlovi"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newProbedHealth creates a Health probing an upstream which takes the latency to respond, and
+// returns it with the number of probes the upstream received.
+func newProbedHealth(t *testing.T, latency time.Duration) (*Health, *atomic.Int32) {
+	t.Helper()
+
+	var probes atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		time.Sleep(latency)
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := config.Default()
+	cfg.Health.ProbeInterval = 10 * time.Millisecond
+	cfg.Health.RequireUpstream = true
+	store := config.NewStore(cfg, nil, func(string) string { return "" })
+
+	client, err := titlovi.NewClient(cfg.Titlovi, titlovi.WithAPIURL(upstream.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHealth("test", store, nil, client), &probes
+}
+
+func TestProbeIgnoresCallerCancellation(t *testing.T) {
+	health, _ := newProbedHealth(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, checks := health.checks(ctx)
+	if c := checks["upstream"]; c.Status != checkOK {
+		t.Errorf("upstream check with a cancelled request = %+v, want ok", c)
+	}
+}
+
+func TestConcurrentChecksShareProbe(t *testing.T) {
+	// The probe takes longer than its result is reused for.
+	health, probes := newProbedHealth(t, 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health.checks(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if got := probes.Load(); got != 1 {
+		t.Errorf("probes = %d, want 1", got)
+	}
+}
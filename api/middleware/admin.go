@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// WithAdminToken only lets through requests carrying the token as a bearer token in the Authorization header.
+//
+// Admin routes are hidden behind a 404 when no token is configured. The token is read on every request, so
+// that a reloaded token applies right away.
+func WithAdminToken(token func() string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := token()
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				slog.WarnContext(r.Context(), "WithAdminToken: rejected request", "path", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,6 +2,9 @@ package middleware
 
 import (
 	"context"
+	"go-titlovi/internal/stremio"
+	"go-titlovi/internal/userconfig"
+	"log/slog"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -13,24 +16,34 @@ const (
 	UserConfigContextKey contextKey = "user-config"
 )
 
+// WithAuth decodes and validates the user configuration the route carries, which handlers find in the context
+// under UserConfigContextKey. Requests with a missing or invalid configuration are rejected with an error
+// describing every problem with it.
 func WithAuth(next http.Handler) http.Handler {
+	return withUserConfig(next, userconfig.Decode)
+}
+
+// WithEditableConfig is WithAuth for the page users edit their configuration on. Configurations that decode
+// but fail validation are let through, so that users can fix them, and handlers must validate them.
+func WithEditableConfig(next http.Handler) http.Handler {
+	return withUserConfig(next, userconfig.DecodeUnvalidated)
+}
+
+// withUserConfig decodes the user configuration the route carries with decode, rejecting requests it fails for.
+func withUserConfig(next http.Handler, decode func(s string) (*userconfig.Config, error)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
-		userConfigEnc, ok := vars["userConfig"]
+		userConfigEnc, ok := vars[stremio.ConfigVar]
 		if !ok {
 			http.Error(w, "No user config passed", http.StatusUnauthorized)
 			return
 		}
 
-		userConfig, err := DecodeUserConfig(userConfigEnc)
+		userConfig, err := decode(userConfigEnc)
 		if err != nil {
-			http.Error(w, "Cannot decode user config", http.StatusUnauthorized)
-			return
-		}
-
-		if userConfig.Username == "" || userConfig.Password == "" {
-			http.Error(w, "user config was invalid", http.StatusUnauthorized)
+			slog.InfoContext(r.Context(), "withUserConfig: rejected user config", "error", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
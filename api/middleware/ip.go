@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPResolver determines the IP address of the client that made a request.
+//
+// Headers carrying the original client address are only honoured when the request was received from
+// one of the trusted proxies, since anyone can set them otherwise.
+type IPResolver struct {
+	trusted []netip.Prefix
+}
+
+// NewIPResolver creates an IPResolver trusting proxies within the provided prefixes.
+func NewIPResolver(trusted []netip.Prefix) *IPResolver {
+	return &IPResolver{trusted: trusted}
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IP addresses.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("parse prefix: %w", err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("parse address: %w", err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// ClientIP returns the IP address of the client that made the request.
+//
+// If the request came through trusted proxies, the forwarding headers are evaluated in the order of
+// Fly-Client-IP, Forwarded (RFC 7239), X-Forwarded-For and X-Real-IP. Forwarding chains are walked
+// from right to left, skipping trusted proxies, so that entries prepended by the client are ignored.
+func (res *IPResolver) ClientIP(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("split remote address: %w", err)
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parse remote address: %w", err)
+	}
+	peer = peer.Unmap()
+
+	if !res.isTrusted(peer) {
+		return peer, nil
+	}
+
+	// Fly.io's proxy sets this itself, overwriting whatever the client sent.
+	if flyIP := r.Header.Get("Fly-Client-IP"); flyIP != "" {
+		if addr, ok := parseNode(flyIP); ok {
+			return addr, nil
+		}
+	}
+
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		return res.walkChain(parseForwarded(forwarded), peer), nil
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		return res.walkChain(parseXForwardedFor(xff), peer), nil
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		if addr, ok := parseNode(realIP); ok {
+			return addr, nil
+		}
+	}
+
+	return peer, nil
+}
+
+// walkChain returns the rightmost address in a forwarding chain that is not a trusted proxy.
+//
+// If an entry cannot be parsed, the closest hop to it is returned as nothing beyond it can be trusted.
+func (res *IPResolver) walkChain(chain []string, peer netip.Addr) netip.Addr {
+	last := peer
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseNode(chain[i])
+		if !ok {
+			return last
+		}
+		if !res.isTrusted(addr) {
+			return addr
+		}
+		last = addr
+	}
+
+	return last
+}
+
+// isTrusted reports whether the address belongs to a trusted proxy.
+func (res *IPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// LimiterKey returns the key to track an address under for rate limiting.
+//
+// IPv6 addresses are bucketed by their /64 prefix, since a single client is usually assigned a whole one.
+func LimiterKey(addr netip.Addr) string {
+	if addr.Is6() {
+		return netip.PrefixFrom(addr, 64).Masked().String()
+	}
+	return addr.String()
+}
+
+// parseXForwardedFor splits all X-Forwarded-For header values into a single chain of nodes.
+func parseXForwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, node := range strings.Split(value, ",") {
+			chain = append(chain, strings.TrimSpace(node))
+		}
+	}
+	return chain
+}
+
+// parseForwarded extracts the "for" parameter of every element across all Forwarded header values.
+//
+// Elements without a "for" parameter are kept as empty nodes so that they break the chain.
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			node := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					node = strings.Trim(val, `"`)
+				}
+			}
+			chain = append(chain, node)
+		}
+	}
+	return chain
+}
+
+// parseNode parses a node from a forwarding header, which may be a bare address or one with a port,
+// with IPv6 addresses optionally enclosed in brackets.
+func parseNode(node string) (netip.Addr, bool) {
+	node = strings.TrimSpace(node)
+
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
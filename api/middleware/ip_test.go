@@ -0,0 +1,136 @@
+package middleware_test
+
+import (
+	"go-titlovi/api/middleware"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := middleware.ParseTrustedProxies("10.0.0.0/8, 2001:db8:ffff::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := middleware.NewIPResolver(trusted)
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"untrusted peer", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"spoofed X-Forwarded-For from untrusted peer", "203.0.113.7:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"spoofed Forwarded from untrusted peer", "203.0.113.7:1234", map[string][]string{"Forwarded": {"for=198.51.100.1"}}, "203.0.113.7"},
+		{"spoofed Fly-Client-IP from untrusted peer", "203.0.113.7:1234", map[string][]string{"Fly-Client-IP": {"198.51.100.1"}}, "203.0.113.7"},
+		{"spoofed X-Real-IP from untrusted peer", "203.0.113.7:1234", map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted peer without headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"Fly-Client-IP", "10.0.0.1:1234", map[string][]string{"Fly-Client-IP": {"198.51.100.1"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.1"},
+		{"malformed Fly-Client-IP", "10.0.0.1:1234", map[string][]string{"Fly-Client-IP": {"garbage"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.2"},
+		{"X-Real-IP", "10.0.0.1:1234", map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "198.51.100.1"},
+		{"malformed X-Real-IP", "10.0.0.1:1234", map[string][]string{"X-Real-IP": {"garbage"}}, "10.0.0.1"},
+		{"X-Forwarded-For", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"multi-hop X-Forwarded-For", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 10.0.0.3, 10.0.0.2"}}, "198.51.100.1"},
+		{"X-Forwarded-For across headers", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}}, "198.51.100.1"},
+		{"X-Forwarded-For prepended by the client", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"192.0.2.1, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"X-Forwarded-For of only trusted proxies", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"malformed X-Forwarded-For entry", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage, 10.0.0.2"}}, "10.0.0.2"},
+		{"Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https"}}, "198.51.100.1"},
+		{"Forwarded before X-Forwarded-For", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.1"},
+		{"multi-hop Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1, for=10.0.0.2"}}, "198.51.100.1"},
+		{"quoted Forwarded with port", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for="198.51.100.1:4711"`}}, "198.51.100.1"},
+		{"quoted Forwarded IPv6", "10.0.0.1:1234", map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"Forwarded element without for", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=198.51.100.1, proto=https"}}, "10.0.0.1"},
+		{"obfuscated Forwarded node", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=_hidden"}}, "10.0.0.1"},
+		{"IPv6 peer", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"trusted IPv6 peer", "[2001:db8:ffff::1]:1234", map[string][]string{"X-Forwarded-For": {"[2001:db8::2]:4711"}}, "2001:db8::2"},
+		{"bracketed IPv6 without port", "[2001:db8:ffff::1]:1234", map[string][]string{"X-Forwarded-For": {"[2001:db8::2]"}}, "2001:db8::2"},
+		{"bare IPv6", "[2001:db8:ffff::1]:1234", map[string][]string{"X-Forwarded-For": {"2001:db8::2"}}, "2001:db8::2"},
+		{"IPv4-mapped IPv6 peer", "[::ffff:10.0.0.1]:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for key, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+
+			got, err := res.ClientIP(r)
+			if err != nil {
+				t.Fatalf("ClientIP: %v", err)
+			}
+			if got != netip.MustParseAddr(tt.want) {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPMalformedRemoteAddr(t *testing.T) {
+	res := middleware.NewIPResolver(nil)
+	for _, remote := range []string{"", "203.0.113.7", "garbage:1234"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		if addr, err := res.ClientIP(r); err == nil {
+			t.Errorf("ClientIP with remote address %q = %s, want an error", remote, addr)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		s     string
+		want  []string
+		valid bool
+	}{
+		{"", nil, true},
+		{"10.0.0.1", []string{"10.0.0.1/32"}, true},
+		{" 10.1.2.3/8 , 2001:db8::1/64 ,", []string{"10.0.0.0/8", "2001:db8::/64"}, true},
+		{"::ffff:10.0.0.1", []string{"10.0.0.1/32"}, true},
+		{"2001:db8::1", []string{"2001:db8::1/128"}, true},
+		{"10.0.0.0/33", nil, false},
+		{"garbage", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := middleware.ParseTrustedProxies(tt.s)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseTrustedProxies(%q) error %v, want valid %t", tt.s, err, tt.valid)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseTrustedProxies(%q) = %v, want %v", tt.s, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].String() != tt.want[i] {
+				t.Errorf("ParseTrustedProxies(%q) = %v, want %v", tt.s, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLimiterKey(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{"2001:db8:1:2:aaaa::1", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff:ffff:ffff:ffff", "2001:db8:1:2::/64"},
+		{"2001:db8:1:3::1", "2001:db8:1:3::/64"},
+	}
+
+	for _, tt := range tests {
+		if got := middleware.LimiterKey(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("LimiterKey(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
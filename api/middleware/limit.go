@@ -2,161 +2,218 @@ package middleware
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"go-titlovi/internal/config"
-	"go-titlovi/internal/logger"
-	"go-titlovi/internal/stremio"
-	"go-titlovi/web"
-	"net"
+	"go-titlovi/internal/metrics"
+	"go-titlovi/internal/userconfig"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
+// RateLimitPolicy describes how many requests a single client is allowed to make to a group of routes.
+type RateLimitPolicy struct {
+	Name    string  // Name of the policy. Clients are tracked separately for each policy.
+	Rate    float64 // How many requests to allow within a second.
+	Burst   int     // How many burst requests do we allow.
+	PerUser bool    // Whether to additionally limit by the user from the decoded user config, regardless of IP.
+}
+
 // client is a wrapper around rate.Limiter that also holds info on when the client was last seen.
 type client struct {
 	limiter  *rate.Limiter
 	lastSeen time.Time
 }
 
-// A map that holds a limiter for each client that made a request. The keys are IP addresses.
-var clients = make(map[string]*client)
-var mtx sync.RWMutex
-
-// getLimiterFromClient returns a rate.Limiter for a specific IP address from the clients map.
-func getLimiterFromClient(ip string) *rate.Limiter {
-	mtx.RLock()
-	c, ok := clients[ip]
-	mtx.RUnlock()
-
-	if !ok {
-		limiter := rate.NewLimiter(rate.Limit(config.RateLimitingRate), config.RateLimitingBurst)
-
-		mtx.Lock()
-		clients[ip] = &client{limiter: limiter, lastSeen: time.Now()}
-		mtx.Unlock()
+// RateLimiter applies token bucket rate limiting to handlers according to a RateLimitPolicy.
+type RateLimiter struct {
+	// A map that holds a limiter for each client that made a request. The keys are made up of
+	// the policy name and either the IP address or the user.
+	clients     map[string]*client
+	policies    map[string]RateLimitPolicy // The current policies by name, which can be changed with SetPolicy.
+	mtx         sync.Mutex
+	idleTimeout time.Duration
+	ipResolver  *IPResolver
+}
 
-		return limiter
+// NewRateLimiter creates a RateLimiter which forgets clients after they have been idle for idleTimeout.
+func NewRateLimiter(idleTimeout time.Duration, ipResolver *IPResolver) *RateLimiter {
+	return &RateLimiter{
+		clients:     make(map[string]*client),
+		policies:    make(map[string]RateLimitPolicy),
+		idleTimeout: idleTimeout,
+		ipResolver:  ipResolver,
 	}
-
-	mtx.Lock()
-	c.lastSeen = time.Now()
-	mtx.Unlock()
-
-	return c.limiter
 }
 
-// cleanupLimiters is meant to be used in a goroutine to periodically clear unused limiters.
-func cleanupLimiters(ctx context.Context) {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// We could technically introduce read locks here for a more granular approach.
-			var toDelete []string
-			mtx.RLock()
-			for ip, c := range clients {
-				if time.Since(c.lastSeen) > config.RateLimitingCleanupTime {
-					toDelete = append(toDelete, ip)
-				}
+// StartCleanup starts a goroutine that periodically clears unused limiters until the context is done.
+func (l *RateLimiter) StartCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				l.cleanup(now)
 			}
-			mtx.RUnlock()
+		}
+	}()
+}
 
-			if len(toDelete) < 0 {
-				for _, ip := range toDelete {
-					delete(clients, ip)
-				}
-				mtx.Unlock()
-			}
+// cleanup deletes all limiters that have not been used since idleTimeout before now.
+func (l *RateLimiter) cleanup(now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > l.idleTimeout {
+			delete(l.clients, key)
 		}
 	}
 }
 
-// InitRateLimitCleanup initializes a cleanup goroutine to periodically clear limiters for rate limiting.
-func InitRateLimitCleanup(ctx context.Context) {
-	go cleanupLimiters(ctx)
-}
+// SetPolicy replaces the policy with the same name, applying it to clients that are already being tracked.
+func (l *RateLimiter) SetPolicy(policy RateLimitPolicy) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
 
-// WithRateLimit applies token bucket rate limiting to a handler.
-func WithRateLimit(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, err := getIP(r)
-		if err != nil {
-			logger.LogError.Printf("WithRateLimit: could not retrieve IP: %s", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+	old, ok := l.policies[policy.Name]
+	l.policies[policy.Name] = policy
+	if !ok || (old.Rate == policy.Rate && old.Burst == policy.Burst) {
+		return
+	}
 
-		limiter := getLimiterFromClient(ip)
-		if !limiter.Allow() {
-			logger.LogInfo.Printf("WithRateLimit: rate-limited %s", ip)
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
-			return
+	now := time.Now()
+	for key, c := range l.clients {
+		if strings.HasPrefix(key, policy.Name+":") {
+			c.limiter.SetLimitAt(now, rate.Limit(policy.Rate))
+			c.limiter.SetBurstAt(now, policy.Burst)
 		}
+	}
+}
+
+// policy returns the current policy with the name.
+func (l *RateLimiter) policy(name string) RateLimitPolicy {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
 
-		next.ServeHTTP(w, r)
-	})
+	return l.policies[name]
 }
 
-// EncodeUserConfig encodes web.UserConfig received from the configuration page to a base64 JSON representation of a stremio.UserConfig.
-func EncodeUserConfig(c web.UserConfig) (string, error) {
-	config := &stremio.UserConfig{
-		Username: c.Username,
-		Password: c.Password,
-	}
+// getLimiter returns the rate.Limiter for a key, creating it according to the policy if needed.
+func (l *RateLimiter) getLimiter(key string, policy RateLimitPolicy, now time.Time) *rate.Limiter {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
 
-	json, err := json.Marshal(config)
-	if err != nil {
-		return "", fmt.Errorf("marshal user config struct: %w", err)
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(policy.Rate), policy.Burst)}
+		l.clients[key] = c
 	}
+	c.lastSeen = now
 
-	return base64.RawURLEncoding.EncodeToString([]byte(json)), nil
+	return c.limiter
 }
 
-// DecodeUserConfig decodes a base64 JSON object into a stremio.UserConfig
-func DecodeUserConfig(c string) (*stremio.UserConfig, error) {
-	data, err := base64.RawURLEncoding.DecodeString(c)
-	if err != nil {
-		return nil, fmt.Errorf("decode user config: %w", err)
-	}
+// Limit returns a middleware applying the policy to a handler.
+//
+// Per-user limiting relies on the user config being present in the request context, so the middleware
+// must be wrapped by WithAuth, e.g. WithAuth(limit(h)), for WithAuth to run first. The policy can later be
+// changed with SetPolicy.
+func (l *RateLimiter) Limit(policy RateLimitPolicy) func(http.Handler) http.Handler {
+	l.SetPolicy(policy)
+	name := policy.Name
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := l.policy(name)
+
+			addr, err := l.ipResolver.ClientIP(r)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Limit: could not retrieve IP", "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			ip := LimiterKey(addr)
 
-	var userConfig = &stremio.UserConfig{}
-	err = json.Unmarshal(data, userConfig)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal user config struct: %w", err)
-	}
+			keys := []string{fmt.Sprintf("%s:ip:%s", policy.Name, ip)}
+			if userConfig, ok := r.Context().Value(UserConfigContextKey).(*userconfig.Config); policy.PerUser && ok && userConfig != nil {
+				keys = append(keys, fmt.Sprintf("%s:user:%s", policy.Name, userConfig.Username))
+			}
 
-	return userConfig, nil
-}
+			now := time.Now()
+			allowed, remaining, retryAfter := l.reserve(keys, policy, now)
 
-// getIP attempts to retrieve the IP through multiple methods from an http.Request.
-func getIP(r *http.Request) (string, error) {
-	var err error
+			reset := retryAfter
+			if allowed && policy.Rate > 0 {
+				// Time until the burst is fully replenished.
+				reset = time.Duration(float64(policy.Burst-remaining) / policy.Rate * float64(time.Second))
+			}
 
-	ip := r.Header.Get("X-Forwarded-For")
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Burst, policyWindow(policy)))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+
+			if !allowed {
+				slog.InfoContext(r.Context(), "Limit: rate-limited", "ip", ip, "policy", policy.Name)
+				metrics.RateLimitedRequests.Inc(policy.Name)
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
 
-	if ip == "" {
-		ip = r.Header.Get("X-Real-IP")
+			next.ServeHTTP(w, r)
+		})
 	}
+}
 
-	if ip == "" {
-		ip, _, err = net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			return "", fmt.Errorf("GetIP: %w", err)
+// reserve takes a token for the request from the limiter of every key. If any of them has no tokens left,
+// none are taken.
+//
+// Returns whether the request is allowed, the lowest number of remaining tokens and, if not allowed,
+// how long until the request would be allowed.
+func (l *RateLimiter) reserve(keys []string, policy RateLimitPolicy, now time.Time) (bool, int, time.Duration) {
+	reservations := make([]*rate.Reservation, 0, len(keys))
+	remaining := policy.Burst
+	var delay time.Duration
+
+	for _, key := range keys {
+		limiter := l.getLimiter(key, policy, now)
+		res := limiter.ReserveN(now, 1)
+		reservations = append(reservations, res)
+
+		delay = max(delay, res.DelayFrom(now))
+		remaining = min(remaining, int(limiter.TokensAt(now)))
+	}
+
+	if delay > 0 {
+		for _, res := range reservations {
+			res.CancelAt(now)
 		}
+		return false, 0, delay
 	}
 
-	if ip == "" {
-		return "", fmt.Errorf("GetIP: no IP found")
+	return true, max(remaining, 0), 0
+}
+
+// policyWindow returns the time in seconds it takes for a fully depleted burst to be replenished.
+func policyWindow(policy RateLimitPolicy) int {
+	if policy.Rate <= 0 {
+		return 0
 	}
+	return ceilSeconds(time.Duration(float64(policy.Burst) / policy.Rate * float64(time.Second)))
+}
 
-	return ip, nil
+// ceilSeconds rounds a duration up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"go-titlovi/internal/userconfig"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// limitedRequest makes a request from the address, as the user if not empty, to the limited handler.
+func limitedRequest(h http.Handler, remote, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	if user != "" {
+		r = r.WithContext(context.WithValue(r.Context(), UserConfigContextKey, &userconfig.Config{Username: user}))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestLimitHeaders(t *testing.T) {
+	l := NewRateLimiter(time.Hour, NewIPResolver(nil))
+	h := l.Limit(RateLimitPolicy{Name: "test", Rate: 0.5, Burst: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{http.StatusOK, "1", "2", ""},
+		{http.StatusOK, "0", "4", ""},
+		{http.StatusTooManyRequests, "0", "2", "2"},
+	}
+
+	for i, tt := range tests {
+		w := limitedRequest(h, "192.0.2.1:1234", "")
+		if w.Code != tt.status {
+			t.Errorf("request %d = %d, want %d", i, w.Code, tt.status)
+		}
+		for header, want := range map[string]string{
+			"RateLimit-Policy":    "2;w=4",
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": tt.remaining,
+			"RateLimit-Reset":     tt.reset,
+			"Retry-After":         tt.retryAfter,
+		} {
+			if got := w.Header().Get(header); got != want {
+				t.Errorf("request %d header %s = %q, want %q", i, header, got, want)
+			}
+		}
+	}
+
+	// Other clients are tracked separately.
+	if w := limitedRequest(h, "192.0.2.2:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("request from another client = %d, want 200", w.Code)
+	}
+}
+
+func TestLimitPerUser(t *testing.T) {
+	l := NewRateLimiter(time.Hour, NewIPResolver(nil))
+	h := l.Limit(RateLimitPolicy{Name: "test", Rate: 0.001, Burst: 1, PerUser: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if w := limitedRequest(h, "192.0.2.1:1234", "user"); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", w.Code)
+	}
+	// The user has no tokens left from any address.
+	if w := limitedRequest(h, "192.0.2.2:1234", "user"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request by the same user from another address = %d, want 429", w.Code)
+	}
+	// The rejected request did not take the token of the address.
+	if w := limitedRequest(h, "192.0.2.2:1234", "other"); w.Code != http.StatusOK {
+		t.Errorf("request by another user from the address of the rejected request = %d, want 200", w.Code)
+	}
+}
+
+func TestReserveCancelsEveryKey(t *testing.T) {
+	l := NewRateLimiter(time.Hour, NewIPResolver(nil))
+	policy := RateLimitPolicy{Name: "test", Rate: 0.001, Burst: 2}
+	now := time.Now()
+
+	if allowed, remaining, _ := l.reserve([]string{"a", "b"}, policy, now); !allowed || remaining != 1 {
+		t.Fatalf("reserve(a, b) = %t with %d remaining, want allowed with 1", allowed, remaining)
+	}
+	if allowed, remaining, _ := l.reserve([]string{"b"}, policy, now); !allowed || remaining != 0 {
+		t.Fatalf("reserve(b) = %t with %d remaining, want allowed with 0", allowed, remaining)
+	}
+	allowed, _, retryAfter := l.reserve([]string{"a", "b"}, policy, now)
+	if allowed || retryAfter <= 0 {
+		t.Fatalf("reserve(a, b) with b depleted = %t after %s, want rejected with a delay", allowed, retryAfter)
+	}
+
+	// The token reserved from a was given back when b rejected the request.
+	if allowed, remaining, _ := l.reserve([]string{"a"}, policy, now); !allowed || remaining != 0 {
+		t.Errorf("reserve(a) = %t with %d remaining, want allowed with 0", allowed, remaining)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	l := NewRateLimiter(time.Minute, NewIPResolver(nil))
+	h := l.Limit(RateLimitPolicy{Name: "test", Rate: 0.001, Burst: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	limitedRequest(h, "192.0.2.1:1234", "")
+	if w := limitedRequest(h, "192.0.2.1:1234", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request with no tokens left = %d, want 429", w.Code)
+	}
+
+	l.cleanup(time.Now().Add(30 * time.Second))
+	if len(l.clients) != 1 {
+		t.Fatalf("clients after cleanup before the idle timeout = %d, want 1", len(l.clients))
+	}
+	if w := limitedRequest(h, "192.0.2.1:1234", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request after cleanup before the idle timeout = %d, want 429", w.Code)
+	}
+
+	l.cleanup(time.Now().Add(2 * time.Minute))
+	if len(l.clients) != 0 {
+		t.Fatalf("clients after cleanup past the idle timeout = %d, want 0", len(l.clients))
+	}
+	// A forgotten client starts over with a full burst.
+	if w := limitedRequest(h, "192.0.2.1:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("request after the client was forgotten = %d, want 200", w.Code)
+	}
+}
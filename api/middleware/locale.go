@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/userconfig"
+	"net/http"
+	"time"
+)
+
+const (
+	LocaleContextKey contextKey = "locale"
+
+	LocaleParam  = "lang" // The query parameter switching the locale.
+	localeCookie = "lang" // The cookie remembering the locale a user switched to.
+)
+
+// WithLocale picks the locale to respond in, which handlers find through Locale. A locale chosen with the lang
+// query parameter wins and is remembered in a cookie, followed by the one in the cookie, the one saved in the user
+// configuration and finally the one negotiated from the Accept-Language header.
+//
+// The cookie is marked Secure if secure is set, i.e. if the addon is served over HTTPS.
+func WithLocale(secure bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			locale := ""
+			if l := r.URL.Query().Get(LocaleParam); i18n.Supported(l) {
+				locale = l
+				http.SetCookie(w, &http.Cookie{
+					Name:     localeCookie,
+					Value:    locale,
+					Path:     "/",
+					MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+					HttpOnly: true,
+					Secure:   secure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			} else if cookie, err := r.Cookie(localeCookie); err == nil && i18n.Supported(cookie.Value) {
+				locale = cookie.Value
+			} else if c, ok := r.Context().Value(UserConfigContextKey).(*userconfig.Config); ok && i18n.Supported(c.Locale) {
+				locale = c.Locale
+			} else {
+				locale = i18n.Negotiate(r.Header.Get("Accept-Language"))
+			}
+
+			w.Header().Set("Content-Language", locale)
+			w.Header().Add("Vary", "Accept-Language, Cookie")
+			ctx := context.WithValue(r.Context(), LocaleContextKey, locale)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Locale returns the locale to respond to the request in, or the fallback locale outside of WithLocale.
+func Locale(ctx context.Context) string {
+	if locale, ok := ctx.Value(LocaleContextKey).(string); ok {
+		return locale
+	}
+	return i18n.Fallback
+}
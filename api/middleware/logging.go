@@ -1,9 +1,12 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"go-titlovi/internal/config"
 	"go-titlovi/internal/logger"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
@@ -36,10 +39,23 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode       // capture status code
 }
 
+// WithLogging logs every request once it has been handled.
+//
+// Every request is assigned an ID, taken from the X-Request-ID header if the client sent a valid one, which is
+// returned in the same header and carried by the request context so that all logs for the request share it.
 func WithLogging(next http.Handler) http.Handler {
 	loggingFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		requestID := r.Header.Get(logger.RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(logger.RequestIDHeader, requestID)
+
+		ctx := logger.WithRequestID(r.Context(), requestID)
+		r = r.WithContext(ctx)
+
 		lw := loggingResponseWriter{
 			ResponseWriter: w, // compose original http.ResponseWriter
 			responseData:   &responseData{},
@@ -49,28 +65,48 @@ func WithLogging(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
-		cacheStatus := w.Header().Get(config.CacheHeader)
-
 		redactedURL, err := redactURL(r.URL.Path)
 		if err != nil {
-			logger.LogError.Printf("WithLogging: failed to redact URL: %s", err.Error())
+			slog.ErrorContext(ctx, "WithLogging: failed to redact URL", "error", err)
 		}
 
-		var msg string
-		if cacheStatus != "" {
-			msg = fmt.Sprintf("method=%s status=%d cache=%s duration=%s url=%s",
-				r.Method, lw.responseData.status, cacheStatus, duration, redactedURL)
-		} else {
-			msg = fmt.Sprintf("method=%s status=%d duration=%s url=%s",
-				r.Method, lw.responseData.status, duration, redactedURL)
+		attrs := []any{
+			"method", r.Method,
+			"status", lw.responseData.status,
+			"size", lw.responseData.size,
+			"duration", duration,
+			"url", redactedURL,
+		}
+		if cacheStatus := w.Header().Get(config.CacheHeader); cacheStatus != "" {
+			attrs = append(attrs, "cache", cacheStatus)
 		}
 
-		logger.LogInfo.Printf("Request: %s", msg)
+		slog.InfoContext(ctx, "Request", attrs...)
 	}
 
 	return http.HandlerFunc(loggingFn)
 }
 
+// isValidRequestID reports whether a request ID sent by a client is safe to log and echo back.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // Never returns an error.
+	return hex.EncodeToString(b)
+}
+
 // redactURL is used to redact any sensitive parts of a raw URL.
 func redactURL(rawURL string) (string, error) {
 	parsedURL, err := url.Parse(rawURL)
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"go-titlovi/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// WithMetrics records the count and duration of requests by route, so that user configs in paths do not
+// end up in labels.
+func WithMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		lw := loggingResponseWriter{
+			ResponseWriter: w,
+			responseData:   &responseData{},
+		}
+
+		next.ServeHTTP(&lw, r)
+
+		route := routeTemplate(r)
+		status := lw.responseData.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		metrics.HTTPRequests.Inc(route, r.Method, strconv.Itoa(status))
+		metrics.HTTPRequestDuration.Observe(metrics.Since(start), route, r.Method)
+	})
+}
+
+// routeTemplate returns the path template of the route the request matched, such as
+// "/{userConfig}/manifest.json", or "unknown" if it matched none.
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unknown"
+}
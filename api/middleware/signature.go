@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"go-titlovi/internal/signing"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// WithSignature rejects requests to serve-subtitle URLs that carry a missing, tampered or expired signature.
+func WithSignature(signer *signing.Signer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+
+			err := signer.Verify(vars["type"], vars["mediaid"], r.URL.Query(), time.Now())
+			if err != nil {
+				slog.InfoContext(r.Context(), "WithSignature: rejected", "mediaid", vars["mediaid"], "error", err)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"go-titlovi/internal/tracing"
+	"net/http"
+	"slices"
+)
+
+// untracedRoutes are polled by infrastructure and would only add noise to traces.
+var untracedRoutes = []string{"/healthz", "/readyz", "/metrics"}
+
+// WithTracing records a server span for every request, continuing the trace of the caller if it sent a
+// traceparent header. Spans are named by route, so that user configs in paths do not end up in traces.
+func WithTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		if slices.Contains(untracedRoutes, route) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, r.Method+" "+route, tracing.KindServer,
+			"http.request.method", r.Method,
+			"http.route", route,
+			"user_agent.original", r.UserAgent(),
+		)
+		defer span.End()
+
+		lw := loggingResponseWriter{
+			ResponseWriter: w,
+			responseData:   &responseData{},
+		}
+
+		next.ServeHTTP(&lw, r.WithContext(ctx))
+
+		status := lw.responseData.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetAttributes("http.response.status_code", status, "http.response.body.size", lw.responseData.size)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(status))
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"cmp"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/userconfig"
+	"slices"
+)
+
+// applyPreferences returns the subtitles the user wants to see, in the order they want them in, out of the
+// subtitles found in the searched languages. The subtitles are not modified, as they are shared through the cache.
+func applyPreferences(subtitles []searchResult, prefs *userconfig.Config, searched []string) []searchResult {
+	preferred := preferredLanguages(prefs, searched)
+
+	var shown []searchResult
+	for _, sub := range subtitles {
+		if prefs.OnlyIMDb && sub.Source == sourceTitle {
+			continue
+		}
+		if len(prefs.Languages) > 0 && languages.Default.Rank(sub.Lang, preferred) == len(preferred) {
+			continue
+		}
+		shown = append(shown, sub)
+	}
+
+	switch prefs.Ranking {
+	case userconfig.RankingTitlovi:
+	case userconfig.RankingNewest:
+		// Titlovi.com numbers subtitles in the order they were uploaded.
+		slices.SortStableFunc(shown, func(a, b searchResult) int {
+			return cmp.Or(
+				languages.Default.Rank(a.Lang, preferred)-languages.Default.Rank(b.Lang, preferred),
+				cmp.Compare(b.Id, a.Id),
+			)
+		})
+	default:
+		shown = rankSubtitles(shown, preferred)
+	}
+
+	if prefs.MaxPerLanguage > 0 {
+		counts := make(map[string]int)
+		shown = slices.DeleteFunc(shown, func(sub searchResult) bool {
+			counts[sub.Lang]++
+			return counts[sub.Lang] > prefs.MaxPerLanguage
+		})
+	}
+
+	return shown
+}
+
+// preferredLanguages returns the languages the user prefers, in order, defaulting to the searched languages.
+// Languages written in the preferred script move ahead of the same language written in another.
+func preferredLanguages(prefs *userconfig.Config, searched []string) []string {
+	preferred := searched
+	if len(prefs.Languages) > 0 {
+		preferred = prefs.Languages
+	}
+	preferred = languages.Default.Canonical(preferred)
+	if prefs.Script == "" {
+		return preferred
+	}
+
+	script := languages.Script(prefs.Script)
+	ordered := make([]string, 0, len(preferred))
+	for _, name := range preferred {
+		if slices.Contains(ordered, name) {
+			continue
+		}
+		if l := languages.Default.Resolve(name); l.Script != script {
+			i := slices.IndexFunc(preferred, func(p string) bool {
+				alt := languages.Default.Resolve(p)
+				return alt.Code == l.Code && alt.Script == script
+			})
+			if i >= 0 && !slices.Contains(ordered, preferred[i]) {
+				ordered = append(ordered, preferred[i])
+			}
+		}
+		ordered = append(ordered, name)
+	}
+	return ordered
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"go-titlovi/api/middleware"
+	"go-titlovi/internal/config"
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/idmap"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/metadata"
+	"go-titlovi/internal/signing"
+	"go-titlovi/internal/stremio"
+	"go-titlovi/internal/titlovi"
+	"go-titlovi/internal/titlovi/titlovitest"
+	"go-titlovi/internal/tracing"
+	"go-titlovi/internal/tracing/tracingtest"
+	"go-titlovi/internal/userconfig"
+	"go-titlovi/web"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// addonAddress is the public address the addon under test builds URLs with.
+const addonAddress = "http://addon.test"
+
+// newTestAddon starts the whole addon against a fake Titlovi.com, returning both.
+func newTestAddon(t *testing.T) (*httptest.Server, *titlovitest.Server) {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.Server.Address = addonAddress
+	return startAddon(t, config.NewStore(cfg, nil, func(string) string { return "" }))
+}
+
+// startAddon starts the whole addon with the configuration store against a fake Titlovi.com, returning both.
+func startAddon(t *testing.T, store *config.Store) (*httptest.Server, *titlovitest.Server) {
+	t.Helper()
+
+	fake := titlovitest.NewServer()
+	t.Cleanup(fake.Close)
+
+	cfg := store.Current()
+	client, err := titlovi.NewClient(cfg.Titlovi, titlovi.WithAPIURL(fake.APIURL()), titlovi.WithDownloadURL(fake.DownloadURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := ristretto.NewCache(&ristretto.Config{NumCounters: 1e4, MaxCost: 1 << 20, BufferItems: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := idmap.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := web.NewAssets("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := signing.NewSigner([]byte("secret"), cfg.Signing.TTL, cfg.Signing.Window)
+	limiter := middleware.NewRateLimiter(cfg.RateLimit.CleanupTime, middleware.NewIPResolver(nil))
+	health := NewHealth("test", store, cache, client)
+
+	router, err := BuildRouter(store, client, cache, signer, limiter, health, ids, meta, assets, "test")
+	if err != nil {
+		t.Fatalf("BuildRouter: %v", err)
+	}
+
+	addon := httptest.NewServer(router)
+	t.Cleanup(addon.Close)
+	return addon, fake
+}
+
+// get requests the path from the server, returning the status and body.
+func get(t *testing.T, server *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestRouterSubtitles(t *testing.T) {
+	addon, fake := newTestAddon(t)
+	fake.AddUser("user", "secret")
+	fake.AddSubtitle(titlovitest.Subtitle{Query: "tt0111161", Data: titlovi.SubtitleData{Id: 10, Type: 1, Lang: "Hrvatski"}})
+	fake.AddSubtitle(titlovitest.Subtitle{Query: "tt0111161", Data: titlovi.SubtitleData{Id: 11, Type: 1, Lang: "Srpski"}})
+
+	text := titlovitest.SRT("Čaša žute šljive")
+	encoded, err := titlovitest.Encode(text, "windows-1250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := titlovitest.ZIP(titlovitest.File{Name: "movie.srt", Data: encoded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetPayload("1", "10", archive)
+	// Titlovi.com serves some older subtitles as RAR archives, which cannot be extracted.
+	fake.SetPayload("1", "11", titlovitest.RAR(titlovitest.File{Name: "movie.srt", Data: encoded}))
+
+	status, body := get(t, addon, "/manifest.json")
+	if status != http.StatusOK || !strings.Contains(string(body), `"configurationRequired":true`) {
+		t.Fatalf("GET /manifest.json = %d %s, want a manifest requiring configuration", status, body)
+	}
+
+	enc, err := userconfig.Encode(userconfig.Config{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = get(t, addon, "/"+enc+"/subtitles/movie/tt0111161.json")
+	if status != http.StatusOK {
+		t.Fatalf("GET subtitles = %d %s", status, body)
+	}
+	var resp stremio.SubtitlesResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Subtitles) != 2 {
+		t.Fatalf("got %d subtitles, want 2", len(resp.Subtitles))
+	}
+
+	serve := make(map[string]int)
+	for _, s := range resp.Subtitles {
+		path, ok := strings.CutPrefix(s.Url, addonAddress)
+		if !ok {
+			t.Fatalf("subtitle URL %q is not served by the addon", s.Url)
+		}
+		status, body := get(t, addon, path)
+		serve[s.Id] = status
+		if s.Id == "10" && string(body) != text {
+			t.Errorf("served subtitle = %q, want %q", body, text)
+		}
+	}
+	if serve["10"] != http.StatusOK {
+		t.Errorf("serving the ZIP subtitle = %d, want 200", serve["10"])
+	}
+	if serve["11"] != http.StatusInternalServerError {
+		t.Errorf("serving the RAR subtitle = %d, want 500", serve["11"])
+	}
+	if got := fake.Requests(titlovi.EndpointLogin); got != 1 {
+		t.Errorf("logins = %d, want 1", got)
+	}
+
+	status, _ = get(t, addon, "/"+enc+"/subtitles/movie/tt0111161/filename=movie.mkv.json")
+	if status != http.StatusOK {
+		t.Errorf("GET subtitles with extra arguments = %d, want 200", status)
+	}
+
+	status, _ = get(t, addon, "/garbage/subtitles/movie/tt0111161.json")
+	if status != http.StatusUnauthorized {
+		t.Errorf("GET subtitles with a malformed config = %d, want 401", status)
+	}
+}
+
+func TestRouterSubtitlesUpstreamErrors(t *testing.T) {
+	addon, fake := newTestAddon(t)
+	fake.AddUser("user", "secret")
+
+	enc, err := userconfig.Encode(userconfig.Config{Username: "user", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := get(t, addon, "/"+enc+"/subtitles/movie/tt0111161.json"); status != http.StatusUnauthorized {
+		t.Errorf("GET subtitles with wrong credentials = %d %s, want 401", status, body)
+	}
+
+	enc, err = userconfig.Encode(userconfig.Config{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.InjectFaults(titlovi.EndpointSearch, titlovitest.Fault{Status: http.StatusTooManyRequests, RetryAfter: "3600"})
+	if status, body := get(t, addon, "/"+enc+"/subtitles/movie/tt0111162.json"); status != http.StatusTooManyRequests {
+		t.Errorf("GET subtitles while rate limited by Titlovi.com = %d %s, want 429", status, body)
+	}
+}
+
+func TestMetricsAreNotPublic(t *testing.T) {
+	addon, _ := newTestAddon(t)
+	if status, _ := get(t, addon, "/metrics"); status != http.StatusNotFound {
+		t.Errorf("GET /metrics from the addon = %d, want 404", status)
+	}
+
+	cfg := config.Default()
+	cfg.Server.MetricsPort = "9091"
+	metrics := httptest.NewServer(BuildMetricsServer(cfg).Handler)
+	t.Cleanup(metrics.Close)
+	if status, body := get(t, metrics, "/metrics"); status != http.StatusOK || !strings.Contains(string(body), "# TYPE") {
+		t.Errorf("GET /metrics from the metrics server = %d %s, want the metrics", status, body)
+	}
+}
+
+func TestRouterTracing(t *testing.T) {
+	collector := tracingtest.NewCollector()
+	t.Cleanup(collector.Close)
+	provider := tracing.NewProvider(tracing.Resource{ServiceName: "test"}, tracing.NewOTLPExporter(collector.Endpoint()), 1)
+	tracing.SetProvider(provider)
+	t.Cleanup(func() {
+		tracing.SetProvider(nil)
+		_ = provider.Shutdown(context.Background())
+	})
+
+	addon, fake := newTestAddon(t)
+	fake.AddUser("user", "secret")
+	enc, err := userconfig.Encode(userconfig.Config{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, err := http.NewRequest(http.MethodGet, addon.URL+"/"+enc+"/subtitles/movie/tt0111161.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(tracing.TraceparentHeader, "00-"+traceID+"-"+parentID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+
+	var server, client bool
+	for _, s := range collector.Spans() {
+		if s.TraceID != traceID {
+			t.Errorf("span %q is in trace %s, want the trace of the caller", s.Name, s.TraceID)
+		}
+		if route, _ := s.Attribute("http.route"); strings.Contains(s.Name, enc) || strings.Contains(route, enc) {
+			t.Errorf("span %q records the user config", s.Name)
+		}
+		switch s.Kind {
+		case tracing.KindServer:
+			server = s.ParentSpanID == parentID
+		case tracing.KindClient:
+			client = true
+		}
+	}
+	if !server || !client {
+		t.Errorf("got a server span under the caller %t and a span toward Titlovi.com %t, want both", server, client)
+	}
+}
+
+func TestConfigureInvalidUserConfig(t *testing.T) {
+	addon, _ := newTestAddon(t)
+
+	// Decodes, but was saved with a ranking the addon no longer offers.
+	enc, err := userconfig.Encode(userconfig.Config{Username: "user", Password: "secret", Ranking: "popular"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := get(t, addon, "/"+enc+"/subtitles/movie/tt0111161.json"); status != http.StatusUnauthorized {
+		t.Errorf("GET subtitles with an invalid config = %d, want 401", status)
+	}
+
+	status, body := get(t, addon, "/"+enc+"/configure")
+	if status != http.StatusOK {
+		t.Fatalf("GET configure with an invalid config = %d, want 200", status)
+	}
+	if want := i18n.T(i18n.Fallback, "error.ranking"); !strings.Contains(string(body), want) {
+		t.Errorf("configure page does not show %q", want)
+	}
+	if !strings.Contains(string(body), `value="user"`) {
+		t.Error("configure page is not pre-filled with the saved username")
+	}
+
+	if status, _ := get(t, addon, "/garbage/configure"); status != http.StatusUnauthorized {
+		t.Errorf("GET configure with a malformed config = %d, want 401", status)
+	}
+}
+
+func TestConfigureLimitsMalformedConfigs(t *testing.T) {
+	addon, _ := newTestAddon(t)
+
+	limited := false
+	for range config.Default().RateLimit.Configure.Burst + 1 {
+		status, _ := get(t, addon, "/garbage/configure")
+		limited = limited || status == http.StatusTooManyRequests
+	}
+	if !limited {
+		t.Error("requests to configure with a malformed config were not rate limited")
+	}
+}
+
+func TestReloadAppliesToManifestAndAdminToken(t *testing.T) {
+	env := map[string]string{"PORT": "5555", "SERVER_ADDRESS": addonAddress}
+	cfg, err := config.Load(nil, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := config.NewStore(cfg, nil, func(key string) string { return env[key] })
+	addon, _ := startAddon(t, store)
+
+	english := languages.Default.Resolve("English").DisplayName(i18n.Fallback)
+	if _, body := get(t, addon, "/manifest.json"); !strings.Contains(string(body), english) {
+		t.Fatalf("manifest does not describe %s, which is searched in: %s", english, body)
+	}
+	if status := postReload(t, addon, ""); status != http.StatusNotFound {
+		t.Errorf("POST /admin/reload without an admin token configured = %d, want 404", status)
+	}
+
+	env["TITLOVI_LANGUAGES"] = "Bosanski,Hrvatski"
+	env["ADMIN_TOKEN"] = "token"
+	if _, err := store.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if _, body := get(t, addon, "/manifest.json"); strings.Contains(string(body), english) {
+		t.Errorf("manifest still describes %s after it was reloaded away: %s", english, body)
+	}
+	if status := postReload(t, addon, ""); status != http.StatusUnauthorized {
+		t.Errorf("POST /admin/reload without the reloaded token = %d, want 401", status)
+	}
+	if status := postReload(t, addon, "token"); status != http.StatusOK {
+		t.Errorf("POST /admin/reload with the reloaded token = %d, want 200", status)
+	}
+}
+
+// postReload requests a reload from the addon with the bearer token, if not empty, returning the status.
+func postReload(t *testing.T, addon *httptest.Server, token string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, addon.URL+"/admin/reload", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestTitleFallbackIsCachedByTitle(t *testing.T) {
+	addon, fake := newTestAddon(t)
+	fake.AddUser("user", "secret")
+	fake.AddSubtitle(titlovitest.Subtitle{Query: "Ko to tamo peva", Data: titlovi.SubtitleData{Id: 20, Type: titlovi.TypeMovie, Year: 1980, Lang: "Srpski"}})
+	fake.AddSubtitle(titlovitest.Subtitle{Query: "Maratonci trce pocasni krug", Data: titlovi.SubtitleData{Id: 21, Type: titlovi.TypeMovie, Year: 1982, Lang: "Srpski"}})
+
+	enc, err := userconfig.Encode(userconfig.Config{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No subtitles are linked to the IMDb ID, so the item is searched for by the titles in the file names.
+	for filename, want := range map[string]string{
+		"Ko.to.tamo.peva.1980.mkv":             "20",
+		"Maratonci.trce.pocasni.krug.1982.mkv": "21",
+	} {
+		status, body := get(t, addon, "/"+enc+"/subtitles/movie/tt0000001/filename="+filename+".json")
+		if status != http.StatusOK {
+			t.Fatalf("GET subtitles for %s = %d %s", filename, status, body)
+		}
+		var resp stremio.SubtitlesResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Subtitles) != 1 || resp.Subtitles[0].Id != want {
+			t.Errorf("subtitles for %s = %+v, want only %s", filename, resp.Subtitles, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"go-titlovi/internal/idmap"
+	"go-titlovi/internal/metadata"
+	"go-titlovi/internal/stremio"
+	"go-titlovi/internal/titlovi"
+	"go-titlovi/internal/tracing"
+	"log/slog"
+)
+
+// Sources search results are found by.
+const (
+	sourceIMDb  = "imdb"  // Found by the IMDb ID of the item.
+	sourceTitle = "title" // Found by the title and year of the item.
+)
+
+// searchResult is a subtitle found on Titlovi.com, along with how it was found.
+type searchResult struct {
+	titlovi.SubtitleData
+	Source string
+}
+
+// searchRequest describes what to search Titlovi.com for.
+type searchRequest struct {
+	query     idmap.Query    // The IMDb item, if the ID could be resolved to one.
+	resolved  bool           // Whether query is set.
+	meta      *metadata.Meta // The title and year to fall back to, if known.
+	mediaType string         // The Stremio type of the item.
+	season    string         // The season to search for by title, if the item is an episode.
+	episode   string         // The episode to search for by title, if the item is an episode.
+}
+
+// cacheKey returns the key to cache the results of the request for the item with the ID under. Results found
+// by title depend on the title and year, which may come from the file name of the user, so those are part of
+// the key.
+func (req searchRequest) cacheKey(id string) string {
+	if req.meta == nil {
+		return id
+	}
+	return fmt.Sprintf("%s|%s|%d|%s", id, req.meta.Title, req.meta.Year, req.meta.Type)
+}
+
+// searchSubtitles searches Titlovi.com by IMDb ID and, if that finds nothing, by title and year, merging the
+// results of both. Results found by title are filtered by year and type, as titles are ambiguous.
+func searchSubtitles(ctx context.Context, client *titlovi.Client, req searchRequest, langs []string, username, password string) ([]searchResult, error) {
+	var results []searchResult
+
+	if req.resolved {
+		subs, err := client.Search(ctx, req.query.IMDb, req.query.Season, req.query.Episode, langs, username, password)
+		if err != nil {
+			return nil, fmt.Errorf("search by IMDb ID: %w", err)
+		}
+		results = mergeResults(results, subs, sourceIMDb)
+	}
+
+	if len(results) > 0 || req.meta == nil {
+		return results, nil
+	}
+
+	ctx, span := tracing.Start(ctx, "search.title_fallback", tracing.KindInternal,
+		"search.title", req.meta.Title,
+		"search.year", req.meta.Year,
+	)
+	defer span.End()
+
+	subs, err := client.Search(ctx, req.meta.Title, req.season, req.episode, langs, username, password)
+	if err != nil {
+		span.RecordError(err)
+		if !req.resolved {
+			return nil, fmt.Errorf("search by title: %w", err)
+		}
+		// Having searched by IMDb ID already, finding nothing is better than failing.
+		slog.WarnContext(ctx, "searchSubtitles: failed to search by title", "error", err)
+		return results, nil
+	}
+
+	matching := filterByMeta(subs, req.meta, req.mediaType)
+	span.SetAttributes("search.found", len(subs), "search.matching", len(matching))
+	slog.InfoContext(ctx, "searchSubtitles: searched by title", "title", req.meta.Title, "year", req.meta.Year, "found", len(subs), "matching", len(matching))
+
+	return mergeResults(results, matching, sourceTitle), nil
+}
+
+// filterByMeta returns the subtitles whose year and type match the metadata. Years may differ by one, since
+// release years vary between countries, and subtitles without a year or type are kept.
+func filterByMeta(subs []titlovi.SubtitleData, meta *metadata.Meta, mediaType string) []titlovi.SubtitleData {
+	if meta.Type != "" {
+		mediaType = meta.Type
+	}
+
+	var matching []titlovi.SubtitleData
+	for _, sub := range subs {
+		if meta.Year > 0 && sub.Year > 0 && (sub.Year < int64(meta.Year)-1 || sub.Year > int64(meta.Year)+1) {
+			continue
+		}
+		if mediaType == "movie" && sub.Type == titlovi.TypeSeries || mediaType == "series" && sub.Type == titlovi.TypeMovie {
+			continue
+		}
+		matching = append(matching, sub)
+	}
+	return matching
+}
+
+// mergeResults appends the subtitles found by the source to the results, skipping those already found.
+func mergeResults(results []searchResult, subs []titlovi.SubtitleData, source string) []searchResult {
+	seen := make(map[int64]bool, len(results))
+	for _, r := range results {
+		seen[r.Id] = true
+	}
+
+	for _, sub := range subs {
+		if seen[sub.Id] {
+			continue
+		}
+		seen[sub.Id] = true
+		results = append(results, searchResult{SubtitleData: sub, Source: source})
+	}
+	return results
+}
+
+// itemMeta returns the title and year to search for the item by, taken from the catalog or else from the file
+// name Stremio passed, or nil if neither has them.
+func itemMeta(catalog *metadata.Catalog, id stremio.VideoID, extra stremio.SubtitlesExtra) *metadata.Meta {
+	if m, ok := catalog.Lookup(id.ID); ok {
+		return &m
+	}
+	if m, ok := metadata.FromFilename(extra.Filename); ok {
+		return &m
+	}
+	return nil
+}
+
+// countSources returns how many of the results every source found.
+func countSources(results []searchResult) map[string]int {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Source]++
+	}
+	return counts
+}
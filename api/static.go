@@ -0,0 +1,59 @@
+package api
+
+import (
+	"go-titlovi/internal/config"
+	"go-titlovi/web"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// staticHandler serves the static files of the web pages.
+//
+// Embedded files are read and hashed once, and clients cache them for a day. Files read from the web directory
+// in development are read on every request and revalidated by clients every time.
+func staticHandler(assets *web.Assets) http.HandlerFunc {
+	static := assets.Static()
+
+	entries := make(map[string]*cacheEntry)
+	if !assets.Reloads() {
+		err := fs.WalkDir(static, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := fs.ReadFile(static, name)
+			if err != nil {
+				return err
+			}
+			entries[name] = newCacheEntryAt(data, time.Time{})
+			return nil
+		})
+		if err != nil {
+			slog.Error("staticHandler: failed to read embedded files", "error", err)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["path"]
+
+		if !assets.Reloads() {
+			entry, ok := entries[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			serveCacheEntry(w, r, name, config.StaticCacheControl, entry)
+			return
+		}
+
+		data, err := fs.ReadFile(static, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		serveCacheEntry(w, r, name, config.DevStaticCacheControl, newCacheEntryAt(data, time.Time{}))
+	}
+}
@@ -1,94 +1,263 @@
 package config
 
 import (
-	"fmt"
-	"go-titlovi/internal/logger"
-	"go-titlovi/internal/stremio"
-	"html/template"
-	"os"
-	"strconv"
 	"time"
 )
 
-// This is the manifest of the addon that will be shown to Stremio
-// in order to describe some general information about the addon.
-var Manifest = stremio.Manifest{
-	Id:          "com.github.titlovi-unofficial.stremio",
-	Version:     "0.0.1",
-	Name:        "Titlovi.com Unofficial",
-	Description: "Unofficial addon for fetching subtitles from Titlovi.com.",
-	Types:       []string{"movie", "series"},
-	Resources:   []string{"subtitles"},
-	IdPrefixes:  []string{"tt"},
-	Catalogs:    []stremio.CatalogItem{},
-	BehaviourHints: stremio.BehaviourHints{
-		Configurable:          true,
-		ConfigurationRequired: true,
-	},
-}
-
-var (
-	Development   bool   = false
-	ServerAddress string = ""
-	Port          string = ""
-
-	TitloviApi      string = "https://kodi.titlovi.com/api/subtitles" // Titlovi.com API where we can search for subtitles.
-	TitloviDownload string = "https://titlovi.com/download"           // URL where subtitles can be downloaded from Titlovi.com.
-
-	TitloviLanguages = []string{ // The languages to query for on Titlovi.com.
-		"Bosanski",
-		"Hrvatski",
-		"Srpski",
-		"Cirilica",
-		"English",
-		"Makedonski",
-		"Slovenski",
-	}
-
-	SubtitleSuffix string = "" // This will be appended as a suffix to subtitle languages when returned to Stremio.
-
-	ConfigTemplate *template.Template = template.Must(template.ParseFiles("web/templates/configuration-form.html"))
-)
-
 const (
-	CacheTTL         time.Duration = 60 * time.Minute // How long does a value stay in the cache before being evicted.
-	CacheNumCounters int64         = 1e7              // How many counters will the cache instantiate. See https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-Config
-	CacheMaxCost     int64         = 1 << 28          // Max size of the cache in bytes. Roughly 256MB. See https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-Config
-	CacheBufferItems int64         = 64               // Max size of the get buffer for the cache. See https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-Config
-
 	CacheHeader string = "Cache-Status" // Header to set to indicate cache status.
 	CacheHit    string = "HIT"          // Set if the cache was hit.
 	CacheMiss   string = "MISS"         // Set if not hit.
+	CacheStale  string = "STALE"        // Set if a value that is no longer fresh was served because Titlovi.com was unavailable.
 
-	TitloviClientRetryAttempts uint          = 3                      // How many times to retry a failed request to Titlovi.com.
-	TitloviClientRetryDelay    time.Duration = 500 * time.Millisecond // The delay in-between retries for requests to Titlovi.com.
+	ManifestCacheControl  string = "public, max-age=3600"  // Cache-Control for the manifest, which only changes on deploys.
+	SubtitlesCacheControl string = "private, max-age=600"  // Cache-Control for search results, which are user-specific and may change.
+	ServeCacheControl     string = "public, max-age=86400" // Cache-Control for served subtitles, which never change for a given media ID.
+	ConfigureCacheControl string = "no-store"              // Cache-Control for the configuration page, which may contain credentials.
+	StaticCacheControl    string = "public, max-age=86400" // Cache-Control for static files of the web pages, which only change on deploys.
+	DevStaticCacheControl string = "no-cache"              // Cache-Control for static files read from the web directory, which change while developing.
 
-	RateLimitingRate        int           = 2               // How many requests to allow within a second.
-	RateLimitingBurst       int           = 3               // How many burst requests do we allow.
-	RateLimitingCleanupTime time.Duration = 3 * time.Minute // The duration to hold a single rate limiter for a client for. After this, it is deleted.
+	SubtitleSuffix string = "" // This will be appended as a suffix to subtitle languages when returned to Stremio.
 )
 
-// InitConfig initializes some global variables from the environment.
-func InitConfig() {
-	var err error
+// Config holds all settings of the addon that can be changed without a rebuild.
+//
+// It is built by Load from defaults, an optional YAML file, environment variables and flags.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Addon       AddonConfig       `yaml:"addon"`
+	Titlovi     TitloviConfig     `yaml:"titlovi"`
+	Cache       CacheConfig       `yaml:"cache"`
+	RateLimit   RateLimitConfig   `yaml:"rateLimit"`
+	Signing     SigningConfig     `yaml:"signing"`
+	Health      HealthConfig      `yaml:"health"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	IDMap       IDMapConfig       `yaml:"idMap"`
+	TitleSearch TitleSearchConfig `yaml:"titleSearch"`
+}
 
-	Port = os.Getenv("PORT")
-	if Port == "" {
-		logger.LogFatal.Fatalf("InitConfig: The environment variable PORT must be supplied")
-	}
+// ServerConfig configures the HTTP server of the addon.
+type ServerConfig struct {
+	Port            string        `yaml:"port"`            // The port to listen on.
+	Address         string        `yaml:"address"`         // The public base URL of the addon. Defaults to http://127.0.0.1 with the port.
+	Development     bool          `yaml:"development"`     // Whether the addon runs in development mode.
+	WebDir          string        `yaml:"webDir"`          // In development mode, a directory to read templates and static files from on every use instead of the embedded ones.
+	TrustedProxies  []string      `yaml:"trustedProxies"`  // CIDRs of proxies whose forwarding headers are trusted to carry the client IP.
+	ReadTimeout     time.Duration `yaml:"readTimeout"`     // How long reading a whole request can take.
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`    // How long writing a response can take.
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`     // How long a keep-alive connection is kept open while idle.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"` // How long to wait for in-flight requests when shutting down.
+	DrainDelay      time.Duration `yaml:"drainDelay"`      // How long to keep serving while reporting not ready before shutting down, so load balancers stop routing here.
+	AdminToken      string        `yaml:"adminToken"`      // Bearer token required by the admin endpoints, which are disabled if empty.
+	MetricsPort     string        `yaml:"metricsPort"`     // The port to serve metrics on, apart from the addon. Metrics are not served if empty.
+}
 
-	isDev := os.Getenv("DEVELOPMENT")
-	if isDev == "" {
-		Development = false
-	} else {
-		Development, err = strconv.ParseBool(isDev)
-		if err != nil {
-			logger.LogFatal.Fatalf("InitConfig: cannot set DEVELOPMENT: %s", err)
-		}
-	}
+// AddonConfig configures how the addon presents itself in its manifest.
+type AddonConfig struct {
+	InstanceName string `yaml:"instanceName"` // Appended to the name of the addon, to tell instances apart. Omitted if empty.
+	Logo         string `yaml:"logo"`         // URL of the logo shown in Stremio. The logo served by the addon if empty.
+	Background   string `yaml:"background"`   // URL of the background shown on the addon's page in Stremio.
+	ContactEmail string `yaml:"contactEmail"` // Address users can report problems with the addon to.
+}
+
+// TitloviConfig configures the client toward Titlovi.com.
+type TitloviConfig struct {
+	APIURL      string   `yaml:"apiUrl"`      // Titlovi.com API where we can search for subtitles.
+	DownloadURL string   `yaml:"downloadUrl"` // URL where subtitles can be downloaded from Titlovi.com.
+	Languages   []string `yaml:"languages"`   // The languages to query for on Titlovi.com.
+	ProxyURL    string   `yaml:"proxyUrl"`    // Outbound proxy to send requests through. Uses the standard proxy environment variables if empty.
+	HTTP2       bool     `yaml:"http2"`       // Whether to attempt HTTP/2.
+
+	RequestTimeout time.Duration `yaml:"requestTimeout"` // How long a single attempt of a request can take.
+	OverallTimeout time.Duration `yaml:"overallTimeout"` // How long a whole operation can take, including queueing and retries.
+	QueueTimeout   time.Duration `yaml:"queueTimeout"`   // How long a request waits for a free slot before failing.
+
+	Retry   RetryConfig   `yaml:"retry"`
+	Breaker BreakerConfig `yaml:"breaker"`
+	Pool    PoolConfig    `yaml:"pool"`
+
+	Login    EndpointLimitConfig `yaml:"login"`
+	Search   EndpointLimitConfig `yaml:"search"`
+	Download EndpointLimitConfig `yaml:"download"`
+}
+
+// RetryConfig configures how failed requests toward Titlovi.com are retried.
+type RetryConfig struct {
+	Attempts uint          `yaml:"attempts"` // How many times to try a request in total.
+	Delay    time.Duration `yaml:"delay"`    // The delay before the first retry, doubled for every following one.
+	MaxDelay time.Duration `yaml:"maxDelay"` // The maximum delay in-between retries. Requests asked to wait longer by Titlovi.com are not retried.
+	Jitter   time.Duration `yaml:"jitter"`   // The maximum random delay added to every retry, so that retries from concurrent requests spread out.
+}
+
+// BreakerConfig configures the circuit breakers toward Titlovi.com endpoints.
+type BreakerConfig struct {
+	FailureThreshold    int           `yaml:"failureThreshold"`    // How many consecutive failures open a circuit breaker. Zero disables them.
+	OpenTimeout         time.Duration `yaml:"openTimeout"`         // How long a circuit breaker stays open before probing the endpoint again.
+	HalfOpenMaxRequests int           `yaml:"halfOpenMaxRequests"` // How many probe requests can be in flight while a circuit breaker is half-open.
+}
+
+// PoolConfig configures the connection pool toward Titlovi.com.
+type PoolConfig struct {
+	MaxIdleConns        int           `yaml:"maxIdleConns"`        // How many idle connections to keep in total.
+	MaxIdleConnsPerHost int           `yaml:"maxIdleConnsPerHost"` // How many idle connections to keep per host.
+	MaxConnsPerHost     int           `yaml:"maxConnsPerHost"`     // How many connections to open per host at most. Zero means no limit.
+	IdleConnTimeout     time.Duration `yaml:"idleConnTimeout"`     // How long an idle connection is kept before being closed.
+}
+
+// EndpointLimitConfig configures how many requests can be made toward a single Titlovi.com endpoint.
+type EndpointLimitConfig struct {
+	MaxInFlight int     `yaml:"maxInFlight"` // How many requests can be in flight at once.
+	Rate        float64 `yaml:"rate"`        // How many requests to start within a second.
+	Burst       int     `yaml:"burst"`       // How many burst requests do we allow.
+	MaxQueue    int     `yaml:"maxQueue"`    // How many requests can wait for a slot before new ones are rejected.
+}
+
+// CacheConfig configures the cache of search results and subtitles.
+type CacheConfig struct {
+	TTL         time.Duration `yaml:"ttl"`         // How long does a value stay fresh in the cache.
+	StaleTTL    time.Duration `yaml:"staleTtl"`    // How long does a value stay in the cache after it is no longer fresh, to be served when Titlovi.com is unavailable.
+	NumCounters int64         `yaml:"numCounters"` // How many counters will the cache instantiate. See https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-Config
+	MaxCost     int64         `yaml:"maxCost"`     // Max size of the cache in bytes. See https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-Config
+	BufferItems int64         `yaml:"bufferItems"` // Max size of the get buffer for the cache. See https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-Config
+}
+
+// RateLimitConfig configures the rate limiting of incoming requests.
+type RateLimitConfig struct {
+	CleanupTime time.Duration `yaml:"cleanupTime"` // The duration to hold a single rate limiter for a client for after it was last used.
+	Default     PolicyConfig  `yaml:"default"`     // Applies to routes without a specific policy.
+	Search      PolicyConfig  `yaml:"search"`      // Applies to subtitle searches, each of which may hit Titlovi.com.
+	Serve       PolicyConfig  `yaml:"serve"`       // Applies to subtitle downloads.
+	Configure   PolicyConfig  `yaml:"configure"`   // Applies to the configuration page.
+}
+
+// PolicyConfig configures a single rate limiting policy.
+type PolicyConfig struct {
+	Rate  float64 `yaml:"rate"`  // How many requests to allow within a second.
+	Burst int     `yaml:"burst"` // How many burst requests do we allow.
+}
+
+// SigningConfig configures the signing of serve-subtitle URLs.
+type SigningConfig struct {
+	Secret   string        `yaml:"secret"`   // Secret used to sign URLs. Generated randomly on startup if empty.
+	TTL      time.Duration `yaml:"ttl"`      // How long a signed URL stays valid for.
+	Window   time.Duration `yaml:"window"`   // Expiry times are rounded down to this, so URLs stay stable and cacheable within it.
+	BindUser bool          `yaml:"bindUser"` // Whether URLs are labelled with the user that searched for them. This does not restrict who can use them.
+}
+
+// HealthConfig configures the readiness checks.
+type HealthConfig struct {
+	ProbeUpstream   bool          `yaml:"probeUpstream"`   // Whether to check that Titlovi.com can be reached.
+	ProbeInterval   time.Duration `yaml:"probeInterval"`   // How long the result of a probe is reused for.
+	ProbeTimeout    time.Duration `yaml:"probeTimeout"`    // How long a probe can take.
+	RequireUpstream bool          `yaml:"requireUpstream"` // Whether an unreachable Titlovi.com or an open circuit breaker makes the addon not ready.
+}
+
+// LogConfig configures logging.
+type LogConfig struct {
+	Level  string `yaml:"level"`  // The minimum level to log, one of "debug", "info", "warn" or "error".
+	Format string `yaml:"format"` // The format to log in, either "text" or "json".
+}
+
+// TracingConfig configures the tracing of requests.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`    // Where to export spans to, one of "none", "stdout" or "otlp".
+	Endpoint    string  `yaml:"endpoint"`    // Base URL of the OTLP/HTTP receiver, e.g. an OpenTelemetry Collector on port 4318.
+	SampleRatio float64 `yaml:"sampleRatio"` // Fraction of new traces to record. Traces continued from callers follow their decision.
+	ServiceName string  `yaml:"serviceName"` // Name the addon is reported as.
+}
+
+// IDMapConfig configures how IDs other than IMDb IDs are resolved.
+type IDMapConfig struct {
+	File string `yaml:"file"` // Path to a YAML file mapping Kitsu, TMDB and other IDs to IMDb IDs. Only IMDb IDs are supported if empty.
+}
+
+// TitleSearchConfig configures searching by title for items without subtitles linked to their IMDb IDs.
+type TitleSearchConfig struct {
+	Enabled      bool   `yaml:"enabled"`      // Whether to search by title when searching by IMDb ID finds nothing.
+	MetadataFile string `yaml:"metadataFile"` // Path to a YAML file with the titles and years of items. File names passed by Stremio are used otherwise.
+}
 
-	ServerAddress = os.Getenv("SERVER_ADDRESS")
-	if ServerAddress == "" {
-		ServerAddress = fmt.Sprintf("http://127.0.0.1:%s", Port)
+// Default returns the configuration used for anything not set otherwise.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ReadTimeout:     60 * time.Second,
+			WriteTimeout:    60 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+			DrainDelay:      3 * time.Second,
+		},
+		Titlovi: TitloviConfig{
+			APIURL:      "https://kodi.titlovi.com/api/subtitles",
+			DownloadURL: "https://titlovi.com/download",
+			Languages: []string{
+				"Bosanski",
+				"Hrvatski",
+				"Srpski",
+				"Cirilica",
+				"English",
+				"Makedonski",
+				"Slovenski",
+			},
+			HTTP2:          true,
+			RequestTimeout: 10 * time.Second,
+			OverallTimeout: 30 * time.Second,
+			QueueTimeout:   10 * time.Second,
+			Retry: RetryConfig{
+				Attempts: 3,
+				Delay:    500 * time.Millisecond,
+				MaxDelay: 5 * time.Second,
+				Jitter:   250 * time.Millisecond,
+			},
+			Breaker: BreakerConfig{
+				FailureThreshold:    5,
+				OpenTimeout:         30 * time.Second,
+				HalfOpenMaxRequests: 1,
+			},
+			Pool: PoolConfig{
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 20,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			Login:    EndpointLimitConfig{MaxInFlight: 2, Rate: 1, Burst: 2, MaxQueue: 50},
+			Search:   EndpointLimitConfig{MaxInFlight: 8, Rate: 5, Burst: 8, MaxQueue: 200},
+			Download: EndpointLimitConfig{MaxInFlight: 8, Rate: 10, Burst: 10, MaxQueue: 200},
+		},
+		Cache: CacheConfig{
+			TTL:         60 * time.Minute,
+			StaleTTL:    6 * time.Hour,
+			NumCounters: 1e7,
+			MaxCost:     1 << 28, // Roughly 256MB.
+			BufferItems: 64,
+		},
+		RateLimit: RateLimitConfig{
+			CleanupTime: 3 * time.Minute,
+			Default:     PolicyConfig{Rate: 2, Burst: 3},
+			Search:      PolicyConfig{Rate: 0.5, Burst: 5}, // Players may load a whole episode list at once.
+			Serve:       PolicyConfig{Rate: 2, Burst: 10},  // Players may fetch several subtitles at once.
+			Configure:   PolicyConfig{Rate: 0.2, Burst: 5},
+		},
+		Signing: SigningConfig{
+			TTL:    24 * time.Hour,
+			Window: time.Hour,
+		},
+		Health: HealthConfig{
+			ProbeUpstream: true,
+			ProbeInterval: time.Minute,
+			ProbeTimeout:  5 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		TitleSearch: TitleSearchConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "stremio-addon-titlovi",
+		},
 	}
 }
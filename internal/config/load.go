@@ -0,0 +1,338 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/logger"
+	"io"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a configuration value that can be set through an environment variable or a flag.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// settings lists everything that can be set through environment variables and flags. Anything else
+// can only be set through the configuration file.
+var settings = []setting{
+	{"port", "PORT", "port to listen on", setString(func(c *Config) *string { return &c.Server.Port })},
+	{"server-address", "SERVER_ADDRESS", "public base URL of the addon", setString(func(c *Config) *string { return &c.Server.Address })},
+	{"development", "DEVELOPMENT", "run in development mode", setBool(func(c *Config) *bool { return &c.Server.Development })},
+	{"web-dir", "WEB_DIR", "in development mode, directory to read templates and static files from", setString(func(c *Config) *string { return &c.Server.WebDir })},
+	{"admin-token", "ADMIN_TOKEN", "bearer token for the admin endpoints", setString(func(c *Config) *string { return &c.Server.AdminToken })},
+	{"metrics-port", "METRICS_PORT", "port to serve Prometheus metrics on, apart from the addon", setString(func(c *Config) *string { return &c.Server.MetricsPort })},
+	{"trusted-proxies", "TRUSTED_PROXIES", "comma-separated CIDRs of trusted proxies", setList(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+	{"instance-name", "INSTANCE_NAME", "name of this instance, shown in the addon name", setString(func(c *Config) *string { return &c.Addon.InstanceName })},
+	{"addon-logo", "ADDON_LOGO", "URL of the logo shown in Stremio", setString(func(c *Config) *string { return &c.Addon.Logo })},
+	{"addon-background", "ADDON_BACKGROUND", "URL of the background shown in Stremio", setString(func(c *Config) *string { return &c.Addon.Background })},
+	{"contact-email", "CONTACT_EMAIL", "address users can report problems to", setString(func(c *Config) *string { return &c.Addon.ContactEmail })},
+	{"titlovi-api-url", "TITLOVI_API_URL", "Titlovi.com API URL", setString(func(c *Config) *string { return &c.Titlovi.APIURL })},
+	{"titlovi-download-url", "TITLOVI_DOWNLOAD_URL", "Titlovi.com download URL", setString(func(c *Config) *string { return &c.Titlovi.DownloadURL })},
+	{"titlovi-proxy-url", "TITLOVI_PROXY_URL", "outbound proxy toward Titlovi.com", setString(func(c *Config) *string { return &c.Titlovi.ProxyURL })},
+	{"titlovi-languages", "TITLOVI_LANGUAGES", "comma-separated languages to query Titlovi.com for", setList(func(c *Config) *[]string { return &c.Titlovi.Languages })},
+	{"titlovi-http2", "TITLOVI_HTTP2", "attempt HTTP/2 toward Titlovi.com", setBool(func(c *Config) *bool { return &c.Titlovi.HTTP2 })},
+	{"titlovi-retry-attempts", "TITLOVI_RETRY_ATTEMPTS", "how many times to try a request toward Titlovi.com", setUint(func(c *Config) *uint { return &c.Titlovi.Retry.Attempts })},
+	{"titlovi-request-timeout", "TITLOVI_REQUEST_TIMEOUT", "timeout of a single request toward Titlovi.com", setDuration(func(c *Config) *time.Duration { return &c.Titlovi.RequestTimeout })},
+	{"cache-ttl", "CACHE_TTL", "how long cached values stay fresh", setDuration(func(c *Config) *time.Duration { return &c.Cache.TTL })},
+	{"signing-secret", "SIGNING_SECRET", "secret used to sign serve-subtitle URLs", setString(func(c *Config) *string { return &c.Signing.Secret })},
+	{"signed-url-bind-user", "SIGNED_URL_BIND_USER", "label serve-subtitle URLs with the user, without restricting who can use them", setBool(func(c *Config) *bool { return &c.Signing.BindUser })},
+	{"log-level", "LOG_LEVEL", "minimum level to log", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "LOG_FORMAT", "format to log in, text or json", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"id-map-file", "ID_MAP_FILE", "path to a YAML file mapping other IDs to IMDb IDs", setString(func(c *Config) *string { return &c.IDMap.File })},
+	{"title-search", "TITLE_SEARCH", "search by title when searching by IMDb ID finds nothing", setBool(func(c *Config) *bool { return &c.TitleSearch.Enabled })},
+	{"metadata-file", "METADATA_FILE", "path to a YAML file with the titles and years of items", setString(func(c *Config) *string { return &c.TitleSearch.MetadataFile })},
+	{"tracing-exporter", "TRACING_EXPORTER", "where to export traces to, none, stdout or otlp", setString(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"tracing-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "base URL of the OTLP/HTTP receiver to export traces to", setString(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"tracing-sample-ratio", "TRACING_SAMPLE_RATIO", "fraction of new traces to record", setFloat(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+}
+
+// Load builds the configuration from the defaults, the configuration file, the environment and the flags
+// in args, each taking precedence over the previous one, and validates it.
+//
+// The configuration file is read from the path given with the -config flag or the CONFIG_FILE environment variable.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("addon", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	configPath := fs.String("config", "", "path to a YAML configuration file")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("parse flags: %w", err)
+	}
+
+	c := Default()
+
+	path := *configPath
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := s.set(c, f.Value.String()); err != nil {
+					flagErr = errors.Join(flagErr, fmt.Errorf("flag -%s: %w", s.flag, err))
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if c.Server.Address == "" && c.Server.Port != "" {
+		c.Server.Address = fmt.Sprintf("http://127.0.0.1:%s", c.Server.Port)
+	}
+	c.Server.Address = strings.TrimSuffix(c.Server.Address, "/")
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// loadFile overlays the configuration with the YAML file at path. Keys missing from the file keep their value.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks the configuration and returns an error describing every invalid setting.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(c.Server.Port != "", "server.port must be supplied, e.g. through the PORT environment variable")
+	check(c.Server.Port == "" || (err == nil && port > 0 && port < 65536), "server.port %q is not a valid port", c.Server.Port)
+	metricsPort, err := strconv.Atoi(c.Server.MetricsPort)
+	check(c.Server.MetricsPort == "" || (err == nil && metricsPort > 0 && metricsPort < 65536), "server.metricsPort %q is not a valid port", c.Server.MetricsPort)
+	check(c.Server.MetricsPort == "" || c.Server.MetricsPort != c.Server.Port, "server.metricsPort must differ from server.port, so metrics are not served publicly")
+	check(isHTTPURL(c.Server.Address), "server.address %q is not an http(s) URL", c.Server.Address)
+	for _, proxy := range c.Server.TrustedProxies {
+		check(isPrefixOrAddr(proxy), "server.trustedProxies entry %q is not a CIDR or IP address", proxy)
+	}
+	check(c.Server.WebDir == "" || c.Server.Development, "server.webDir requires server.development")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drainDelay must not be negative")
+
+	check(c.Addon.Logo == "" || isHTTPURL(c.Addon.Logo), "addon.logo %q is not an http(s) URL", c.Addon.Logo)
+	check(c.Addon.Background == "" || isHTTPURL(c.Addon.Background), "addon.background %q is not an http(s) URL", c.Addon.Background)
+	_, err = mail.ParseAddress(c.Addon.ContactEmail)
+	check(c.Addon.ContactEmail == "" || err == nil, "addon.contactEmail %q is not an email address", c.Addon.ContactEmail)
+
+	check(isHTTPURL(c.Titlovi.APIURL), "titlovi.apiUrl %q is not an http(s) URL", c.Titlovi.APIURL)
+	check(isHTTPURL(c.Titlovi.DownloadURL), "titlovi.downloadUrl %q is not an http(s) URL", c.Titlovi.DownloadURL)
+	check(c.Titlovi.ProxyURL == "" || isURL(c.Titlovi.ProxyURL), "titlovi.proxyUrl %q is not a URL", c.Titlovi.ProxyURL)
+	check(len(c.Titlovi.Languages) > 0, "titlovi.languages must not be empty")
+	for _, name := range c.Titlovi.Languages {
+		_, known := languages.Default.Lookup(name)
+		check(known, "titlovi.languages entry %q is not a language Titlovi.com has subtitles in", name)
+	}
+	check(c.Titlovi.Retry.Attempts > 0, "titlovi.retry.attempts must be at least 1")
+	check(c.Titlovi.Retry.Delay >= 0 && c.Titlovi.Retry.MaxDelay >= 0 && c.Titlovi.Retry.Jitter >= 0, "titlovi.retry delays must not be negative")
+	check(c.Titlovi.RequestTimeout >= 0 && c.Titlovi.OverallTimeout >= 0 && c.Titlovi.QueueTimeout >= 0, "titlovi timeouts must not be negative")
+	check(c.Titlovi.Breaker.FailureThreshold >= 0, "titlovi.breaker.failureThreshold must not be negative")
+	for _, endpoint := range []struct {
+		name  string
+		limit EndpointLimitConfig
+	}{{"login", c.Titlovi.Login}, {"search", c.Titlovi.Search}, {"download", c.Titlovi.Download}} {
+		name, limit := endpoint.name, endpoint.limit
+		check(limit.MaxInFlight >= 0 && limit.Rate >= 0 && limit.Burst >= 0 && limit.MaxQueue >= 0, "titlovi.%s limits must not be negative", name)
+		check(limit.Rate == 0 || limit.Burst > 0, "titlovi.%s.burst must be at least 1 when a rate is set", name)
+	}
+
+	check(c.Cache.TTL > 0, "cache.ttl must be positive")
+	check(c.Cache.StaleTTL >= 0, "cache.staleTtl must not be negative")
+	check(c.Cache.NumCounters > 0 && c.Cache.MaxCost > 0 && c.Cache.BufferItems > 0, "cache.numCounters, cache.maxCost and cache.bufferItems must be positive")
+
+	check(c.RateLimit.CleanupTime > 0, "rateLimit.cleanupTime must be positive")
+	for _, p := range []struct {
+		name   string
+		policy PolicyConfig
+	}{{"default", c.RateLimit.Default}, {"search", c.RateLimit.Search}, {"serve", c.RateLimit.Serve}, {"configure", c.RateLimit.Configure}} {
+		name, policy := p.name, p.policy
+		check(policy.Rate > 0 && policy.Burst > 0, "rateLimit.%s rate and burst must be positive", name)
+	}
+
+	check(c.Signing.TTL > 0 && c.Signing.Window > 0, "signing.ttl and signing.window must be positive")
+	check(c.Signing.Window <= c.Signing.TTL, "signing.window must not be longer than signing.ttl")
+
+	check(!c.Health.ProbeUpstream || (c.Health.ProbeInterval > 0 && c.Health.ProbeTimeout > 0), "health.probeInterval and health.probeTimeout must be positive when probing Titlovi.com")
+
+	_, err = logger.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level %q must be one of debug, info, warn or error", c.Log.Level)
+	check(slices.Contains([]string{"text", "json"}, c.Log.Format), "log.format %q must be either text or json", c.Log.Format)
+
+	check(slices.Contains([]string{"none", "stdout", "otlp"}, c.Tracing.Exporter), "tracing.exporter %q must be one of none, stdout or otlp", c.Tracing.Exporter)
+	check(c.Tracing.Exporter != "otlp" || isHTTPURL(c.Tracing.Endpoint), "tracing.endpoint %q is not an http(s) URL", c.Tracing.Endpoint)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.serviceName must not be empty")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// SigningSecret returns the secret to sign URLs with, generating a random one if none was configured.
+//
+// Without a fixed secret, previously issued URLs stop working on restarts and across instances.
+func (c *Config) SigningSecret() ([]byte, bool, error) {
+	if c.Signing.Secret != "" {
+		return []byte(c.Signing.Secret), false, nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, false, fmt.Errorf("generate signing secret: %w", err)
+	}
+	c.Signing.Secret = hex.EncodeToString(secret)
+
+	return []byte(c.Signing.Secret), true, nil
+}
+
+// Usage returns a description of every flag and its environment variable.
+func Usage() string {
+	var b strings.Builder
+	b.WriteString("  -config string\n    \tpath to a YAML configuration file (env CONFIG_FILE)\n")
+	for _, s := range settings {
+		fmt.Fprintf(&b, "  -%s string\n    \t%s (env %s)\n", s.flag, s.usage, s.env)
+	}
+	return b.String()
+}
+
+func setString(field func(c *Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setUint(field func(c *Config) *uint) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return err
+		}
+		*field(c) = uint(n)
+		return nil
+	}
+}
+
+func setFloat(field func(c *Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func setList(field func(c *Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+// isURL reports whether s is an absolute URL.
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isPrefixOrAddr reports whether s is a CIDR or a single IP address.
+func isPrefixOrAddr(s string) bool {
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
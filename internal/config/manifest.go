@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/stremio"
+	"regexp"
+	"strings"
+)
+
+// ManifestVersion is the version of the addon, which builds that are not tagged with a version of their own
+// are reported as, with the build identifier attached as build metadata.
+const ManifestVersion = "0.1.0"
+
+var (
+	// taggedBuild matches build identifiers that are versions themselves, e.g. "v1.2.0".
+	taggedBuild = regexp.MustCompile(`^v?(\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?)$`)
+	// buildMetadata matches the characters semantic versions do not allow in build metadata.
+	buildMetadata = regexp.MustCompile(`[^0-9A-Za-z.-]+`)
+)
+
+// ManifestInfo describes the build and the features of the running addon, which the manifest is
+// assembled from along with the configuration.
+type ManifestInfo struct {
+	Build      string             // The build identifier injected at build time, e.g. a commit hash. Empty for development builds.
+	Schemes    []stremio.IDScheme // The ID schemes items can be identified by.
+	Configured bool               // Whether the manifest is served to a user who has configured the addon.
+	Locale     string             // The locale to describe the addon in. The fallback locale if empty.
+}
+
+// NewManifest assembles the manifest of the addon that will be shown to Stremio, in order to describe some
+// general information about the addon.
+//
+// Users who have not configured the addon yet are asked to, since searching Titlovi.com requires an account.
+func NewManifest(cfg *Config, info ManifestInfo) stremio.Manifest {
+	name := "Titlovi.com Unofficial"
+	if cfg.Addon.InstanceName != "" {
+		name = fmt.Sprintf("%s (%s)", name, cfg.Addon.InstanceName)
+	}
+
+	logo := cfg.Addon.Logo
+	if logo == "" {
+		logo = cfg.Server.Address + "/static/logo.png"
+	}
+
+	return stremio.Manifest{
+		Id:           "com.github.titlovi-unofficial.stremio",
+		Version:      manifestVersion(info.Build),
+		Name:         name,
+		Description:  describe(cfg, info),
+		Types:        []string{"movie", "series"},
+		Resources:    []string{string(stremio.ResourceSubtitles)},
+		IdPrefixes:   stremio.IDPrefixes(info.Schemes...),
+		Catalogs:     []stremio.CatalogItem{},
+		Logo:         logo,
+		Background:   cfg.Addon.Background,
+		ContactEmail: cfg.Addon.ContactEmail,
+		BehaviourHints: stremio.BehaviourHints{
+			Configurable:          true,
+			ConfigurationRequired: !info.Configured,
+		},
+	}
+}
+
+// manifestVersion returns the semantic version of the build.
+func manifestVersion(build string) string {
+	if m := taggedBuild.FindStringSubmatch(build); m != nil {
+		return m[1]
+	}
+	if build = strings.Trim(buildMetadata.ReplaceAllString(build, "-"), "-."); build == "" {
+		build = "dev"
+	}
+	return ManifestVersion + "+" + build
+}
+
+// describe returns the description of the addon in the locale of the info, listing the languages searched in
+// and the optional features enabled.
+func describe(cfg *Config, info ManifestInfo) string {
+	locale := info.Locale
+	if locale == "" {
+		locale = i18n.Fallback
+	}
+
+	names := make([]string, 0, len(cfg.Titlovi.Languages))
+	for _, name := range languages.Default.Canonical(cfg.Titlovi.Languages) {
+		names = append(names, languages.Default.Resolve(name).DisplayName(locale))
+	}
+	sentences := []string{i18n.T(locale, "manifest.description", i18n.JoinList(locale, names))}
+
+	var schemes []string
+	for _, s := range info.Schemes {
+		if s != stremio.SchemeIMDb {
+			schemes = append(schemes, schemeNames[s])
+		}
+	}
+	if len(schemes) > 0 {
+		sentences = append(sentences, i18n.T(locale, "manifest.schemes", i18n.JoinList(locale, schemes)))
+	}
+	if cfg.TitleSearch.Enabled {
+		sentences = append(sentences, i18n.T(locale, "manifest.titleSearch"))
+	}
+
+	if info.Configured {
+		sentences = append(sentences, i18n.T(locale, "manifest.configured"))
+	} else {
+		sentences = append(sentences, i18n.T(locale, "manifest.unconfigured"))
+	}
+	return strings.Join(sentences, " ")
+}
+
+// schemeNames are the names of ID schemes shown to users.
+var schemeNames = map[stremio.IDScheme]string{
+	stremio.SchemeIMDb:  "IMDb",
+	stremio.SchemeKitsu: "Kitsu",
+	stremio.SchemeTMDB:  "TMDB",
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// reloadable lists the settings that can be changed by a reload. Changes to anything else are only
+// applied on restart.
+var reloadable = []string{
+	"rateLimit.default",
+	"rateLimit.search",
+	"rateLimit.serve",
+	"rateLimit.configure",
+	"cache.ttl",
+	"cache.staleTtl",
+	"titlovi.retry",
+	"titlovi.languages",
+	"log.level",
+	"server.adminToken",
+}
+
+// Change is a single setting that differs between two configurations.
+type Change struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Applied bool   `json:"applied"` // Whether the change was applied, as opposed to requiring a restart.
+}
+
+// Store holds the current configuration and reloads it from the same sources it was loaded from.
+type Store struct {
+	current   atomic.Pointer[Config]
+	mtx       sync.Mutex // Serializes reloads.
+	args      []string
+	getenv    func(string) string
+	listeners []func(*Config)
+}
+
+// NewStore creates a Store holding the configuration that was loaded by Load with args and getenv.
+func NewStore(c *Config, args []string, getenv func(string) string) *Store {
+	s := &Store{args: args, getenv: getenv}
+	s.current.Store(c)
+	return s
+}
+
+// Current returns the current configuration. It must not be modified.
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// OnReload registers a function that is called with the new configuration after every successful reload.
+func (s *Store) OnReload(fn func(*Config)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+}
+
+// Reload loads the configuration again and atomically applies the settings that can be changed at runtime.
+//
+// An invalid configuration is rejected as a whole and leaves the current one in place.
+func (s *Store) Reload() ([]Change, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	current := s.Current()
+
+	loaded, err := Load(s.args, s.getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	// A secret generated on startup must survive reloads, or every signed URL would stop working.
+	if loaded.Signing.Secret == "" {
+		loaded.Signing.Secret = current.Signing.Secret
+	}
+
+	next := *current
+	next.RateLimit.Default = loaded.RateLimit.Default
+	next.RateLimit.Search = loaded.RateLimit.Search
+	next.RateLimit.Serve = loaded.RateLimit.Serve
+	next.RateLimit.Configure = loaded.RateLimit.Configure
+	next.Cache.TTL = loaded.Cache.TTL
+	next.Cache.StaleTTL = loaded.Cache.StaleTTL
+	next.Titlovi.Retry = loaded.Titlovi.Retry
+	next.Titlovi.Languages = loaded.Titlovi.Languages
+	next.Log.Level = loaded.Log.Level
+	next.Server.AdminToken = loaded.Server.AdminToken
+
+	changes := Diff(current, loaded)
+	for i, change := range changes {
+		changes[i].Applied = isReloadable(change.Setting)
+		slog.Info("Reload: setting changed", "setting", change.Setting, "old", change.Old, "new", change.New, "applied", changes[i].Applied)
+	}
+
+	s.current.Store(&next)
+	for _, fn := range s.listeners {
+		fn(&next)
+	}
+
+	return changes, nil
+}
+
+// isReloadable reports whether the setting can be changed by a reload.
+func isReloadable(setting string) bool {
+	return slices.ContainsFunc(reloadable, func(prefix string) bool {
+		return setting == prefix || strings.HasPrefix(setting, prefix+".")
+	})
+}
+
+// Diff returns every setting that differs between the configurations, named by their path in the
+// configuration file. Secrets are redacted.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValues("", reflect.ValueOf(*old), reflect.ValueOf(*new), &changes)
+	return changes
+}
+
+// diffValues appends the differences between two values of the same struct type to changes.
+func diffValues(prefix string, old, new reflect.Value, changes *[]Change) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		o, n := old.Field(i), new.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			diffValues(name, o, n, changes)
+			continue
+		}
+
+		if reflect.DeepEqual(o.Interface(), n.Interface()) {
+			continue
+		}
+
+		change := Change{Setting: name, Old: fmt.Sprint(o.Interface()), New: fmt.Sprint(n.Interface())}
+		if name == "signing.secret" || name == "server.adminToken" {
+			change.Old, change.New = "[redacted]", "[redacted]"
+		}
+		*changes = append(*changes, change)
+	}
+}
@@ -0,0 +1,144 @@
+// Package i18n translates the messages shown to users, such as the configuration page and the manifest
+// description, into the languages of the region and English.
+//
+// Messages are kept in a catalog per locale under catalogs/, keyed by message IDs. Every catalog must have every
+// message of the English one, with the same formatting verbs.
+package i18n
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fallback is the locale used when none of the locales a user accepts are supported.
+const Fallback = "en"
+
+// Locale is a locale messages are translated into.
+type Locale struct {
+	Code string // The ISO 639-1 code, e.g. "bs".
+	Name string // The name of the locale in its own language, for the locale switcher.
+}
+
+// Locales are the supported locales, in the order they are offered.
+var Locales = []Locale{
+	{Code: "bs", Name: "Bosanski"},
+	{Code: "hr", Name: "Hrvatski"},
+	{Code: "sr", Name: "Srpski"},
+	{Code: "en", Name: "English"},
+}
+
+//go:embed catalogs/*.yaml
+var catalogFiles embed.FS
+
+// verbPattern matches formatting verbs, which translations must keep.
+var verbPattern = regexp.MustCompile(`%[a-z]`)
+
+// catalogs holds the messages of every locale by message ID.
+var catalogs = mustLoadCatalogs(catalogFiles)
+
+// Supported reports whether messages are translated into the locale.
+func Supported(code string) bool {
+	return slices.ContainsFunc(Locales, func(l Locale) bool { return l.Code == code })
+}
+
+// T returns the message with the ID in the locale, formatted with the arguments. Unsupported locales get the
+// message in the fallback locale.
+func T(locale, id string, args ...any) string {
+	message, ok := catalogs[locale][id]
+	if !ok {
+		message, ok = catalogs[Fallback][id]
+	}
+	if !ok {
+		return id
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Negotiate returns the supported locale the Accept-Language header prefers, or the fallback locale.
+//
+// Serbian, Croatian and Bosnian are close enough for speakers of Montenegrin to prefer them over English.
+func Negotiate(acceptLanguage string) string {
+	type weighted struct {
+		code string
+		q    float64
+	}
+
+	var accepted []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == "cnr" || primary == "sh" {
+			primary = "sr"
+		}
+		if q > 0 && Supported(primary) {
+			accepted = append(accepted, weighted{primary, q})
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	if len(accepted) > 0 {
+		return accepted[0].code
+	}
+	return Fallback
+}
+
+// JoinList joins the items as a list in the locale, e.g. "a, b and c".
+func JoinList(locale string, items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + T(locale, "list.and") + items[len(items)-1]
+}
+
+// mustLoadCatalogs reads the catalog of every supported locale and checks them against the fallback one.
+func mustLoadCatalogs(fsys fs.FS) map[string]map[string]string {
+	loaded := make(map[string]map[string]string, len(Locales))
+	for _, l := range Locales {
+		data, err := fs.ReadFile(fsys, "catalogs/"+l.Code+".yaml")
+		if err != nil {
+			panic(fmt.Sprintf("i18n: read catalog %s: %v", l.Code, err))
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: parse catalog %s: %v", l.Code, err))
+		}
+		loaded[l.Code] = messages
+	}
+
+	var problems []string
+	for code, messages := range loaded {
+		for id, fallback := range loaded[Fallback] {
+			message, ok := messages[id]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%s is missing %s", code, id))
+			case !slices.Equal(verbPattern.FindAllString(message, -1), verbPattern.FindAllString(fallback, -1)):
+				problems = append(problems, fmt.Sprintf("%s has different formatting verbs in %s", code, id))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		panic("i18n: invalid catalogs:\n" + strings.Join(problems, "\n"))
+	}
+	return loaded
+}
@@ -0,0 +1,100 @@
+package i18n
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", Fallback},
+		{"bs", "bs"},
+		{"hr-HR,hr;q=0.9,en;q=0.8", "hr"},
+		{"de-DE,de;q=0.9,sr;q=0.5", "sr"},
+		{"en;q=0.5, bs;q=0.8", "bs"},
+		{"en;q=0.8, bs;q=0.8", "en"},
+		{"SR-Latn-RS", "sr"},
+		{"cnr-ME", "sr"},
+		{"sh", "sr"},
+		{"bs;q=0, hr;q=0.1", "hr"},
+		{"bs;q=abc, hr;q=0.1", "hr"},
+		{"de, fr;q=0.9", Fallback},
+		{"*", Fallback},
+		{" , ;q=1", Fallback},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.acceptLanguage); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestJoinList(t *testing.T) {
+	tests := []struct {
+		locale string
+		items  []string
+		want   string
+	}{
+		{"en", nil, ""},
+		{"en", []string{"a"}, "a"},
+		{"en", []string{"a", "b"}, "a and b"},
+		{"en", []string{"a", "b", "c"}, "a, b and c"},
+		{"bs", []string{"a", "b", "c"}, "a, b i c"},
+		{"de", []string{"a", "b"}, "a and b"},
+	}
+
+	for _, tt := range tests {
+		if got := JoinList(tt.locale, tt.items); got != tt.want {
+			t.Errorf("JoinList(%q, %q) = %q, want %q", tt.locale, tt.items, got, tt.want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := T("hr", "list.and"); got != " i " {
+		t.Errorf("T(hr, list.and) = %q, want the Croatian message", got)
+	}
+	if got := T("de", "list.and"); got != " and " {
+		t.Errorf("T(de, list.and) = %q, want the English message", got)
+	}
+	if got := T("bs", "missing.message"); got != "missing.message" {
+		t.Errorf("T of a missing message = %q, want its ID", got)
+	}
+}
+
+func TestMustLoadCatalogs(t *testing.T) {
+	catalogs := func(sr string) fstest.MapFS {
+		fsys := fstest.MapFS{"catalogs/sr.yaml": {Data: []byte(sr)}}
+		for _, code := range []string{"bs", "hr", "en"} {
+			fsys["catalogs/"+code+".yaml"] = &fstest.MapFile{Data: []byte("greeting: Hello %s\n")}
+		}
+		return fsys
+	}
+
+	tests := []struct {
+		name  string
+		fsys  fstest.MapFS
+		valid bool
+	}{
+		{"complete", catalogs("greeting: Zdravo %s\n"), true},
+		{"missing message", catalogs("other: Zdravo\n"), false},
+		{"different verbs", catalogs("greeting: Zdravo %d\n"), false},
+		{"malformed", catalogs("greeting: [\n"), false},
+		{"missing catalog", fstest.MapFS{"catalogs/en.yaml": {Data: []byte("greeting: Hello\n")}}, false},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if r := recover(); (r == nil) != tt.valid {
+					t.Errorf("mustLoadCatalogs with %s panicked with %v, want valid %t", tt.name, r, tt.valid)
+				}
+			}()
+			mustLoadCatalogs(tt.fsys)
+		}()
+	}
+}
@@ -0,0 +1,141 @@
+// Package idmap resolves Stremio IDs of other schemes, such as Kitsu and TMDB, to the IMDb IDs Titlovi.com is
+// searched by.
+package idmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-titlovi/internal/stremio"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entry maps an item of another scheme to an IMDb item.
+type Entry struct {
+	ID            string `yaml:"id"`            // The ID of the item without episode, e.g. "kitsu:7442".
+	IMDb          string `yaml:"imdb"`          // The IMDb ID of the item, e.g. "tt2560140".
+	Season        int    `yaml:"season"`        // The IMDb season the item is, if it is a single season. Otherwise the season of the ID is kept.
+	EpisodeOffset int    `yaml:"episodeOffset"` // Added to the episode of the ID, for items whose episodes are counted across seasons.
+}
+
+// Query is what Titlovi.com is searched by.
+type Query struct {
+	IMDb    string
+	Season  string
+	Episode string
+}
+
+// Mapping resolves video IDs to queries.
+type Mapping struct {
+	entries map[string]Entry
+}
+
+// New creates a Mapping from the entries.
+func New(entries []Entry) (*Mapping, error) {
+	m := &Mapping{entries: make(map[string]Entry, len(entries))}
+
+	var errs []error
+	for i, e := range entries {
+		id, err := stremio.ParseVideoID(e.ID)
+		switch {
+		case err != nil || id.Season != "" || id.Episode != "":
+			errs = append(errs, fmt.Errorf("entry %d: id %q is not the ID of an item", i+1, e.ID))
+		case id.Scheme == stremio.SchemeIMDb:
+			errs = append(errs, fmt.Errorf("entry %d: id %q is already an IMDb ID", i+1, e.ID))
+		case !strings.HasPrefix(e.IMDb, stremio.SchemeIMDb.Prefix):
+			errs = append(errs, fmt.Errorf("entry %d: imdb %q is not an IMDb ID", i+1, e.IMDb))
+		case e.Season < 0:
+			errs = append(errs, fmt.Errorf("entry %d: season must not be negative", i+1))
+		}
+		if _, ok := m.entries[e.ID]; ok {
+			errs = append(errs, fmt.Errorf("entry %d: id %q is mapped more than once", i+1, e.ID))
+		}
+		m.entries[e.ID] = e
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid ID mapping:\n%w", errors.Join(errs...))
+	}
+	return m, nil
+}
+
+// Load reads a Mapping from a YAML file holding a list of entries. An empty path loads an empty mapping.
+func Load(path string) (*Mapping, error) {
+	if path == "" {
+		return New(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ID mapping: %w", err)
+	}
+
+	var entries []Entry
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse ID mapping %s: %w", path, err)
+	}
+
+	m, err := New(entries)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Len returns the number of mapped items.
+func (m *Mapping) Len() int {
+	return len(m.entries)
+}
+
+// Schemes returns the ID schemes that can be resolved, which is IMDb along with every scheme that has
+// mapped items.
+func (m *Mapping) Schemes() []stremio.IDScheme {
+	schemes := []stremio.IDScheme{stremio.SchemeIMDb}
+	for _, s := range stremio.Schemes {
+		if slices.Contains(schemes, s) {
+			continue
+		}
+		for id := range m.entries {
+			if strings.HasPrefix(id, s.Prefix) {
+				schemes = append(schemes, s)
+				break
+			}
+		}
+	}
+	return schemes
+}
+
+// Resolve returns the query for the video ID, and whether it could be resolved. IMDb IDs always are.
+func (m *Mapping) Resolve(id stremio.VideoID) (Query, bool) {
+	if id.Scheme == stremio.SchemeIMDb {
+		return Query{IMDb: id.ID, Season: id.Season, Episode: id.Episode}, true
+	}
+
+	e, ok := m.entries[id.ID]
+	if !ok {
+		return Query{}, false
+	}
+
+	q := Query{IMDb: e.IMDb, Season: id.Season, Episode: id.Episode}
+	if e.Season > 0 && id.Episode != "" {
+		q.Season = strconv.Itoa(e.Season)
+	}
+	if id.Episode != "" && e.EpisodeOffset != 0 {
+		episode, _ := strconv.Atoi(id.Episode)
+		q.Episode = strconv.Itoa(episode + e.EpisodeOffset)
+	}
+	return q, true
+}
+
+// String formats the query as the Stremio ID of the IMDb item, which results are cached by.
+func (q Query) String() string {
+	return stremio.VideoID{Scheme: stremio.SchemeIMDb, ID: q.IMDb, Season: q.Season, Episode: q.Episode}.String()
+}
@@ -0,0 +1,117 @@
+package idmap_test
+
+import (
+	"go-titlovi/internal/idmap"
+	"go-titlovi/internal/stremio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	m, err := idmap.New([]idmap.Entry{
+		{ID: "kitsu:7442", IMDb: "tt2560140", Season: 1},
+		{ID: "kitsu:8671", IMDb: "tt2560140", Season: 2, EpisodeOffset: -25},
+		{ID: "kitsu:1376", IMDb: "tt0112159"},
+		{ID: "tmdb:1399", IMDb: "tt0944947"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id       string
+		want     idmap.Query
+		resolved bool
+	}{
+		{"tt0903747:1:2", idmap.Query{IMDb: "tt0903747", Season: "1", Episode: "2"}, true},
+		{"tt0111161", idmap.Query{IMDb: "tt0111161"}, true},
+		{"kitsu:7442", idmap.Query{IMDb: "tt2560140"}, true},
+		{"kitsu:7442:3", idmap.Query{IMDb: "tt2560140", Season: "1", Episode: "3"}, true},
+		// Episodes of later seasons are counted from the start of the series.
+		{"kitsu:8671:26", idmap.Query{IMDb: "tt2560140", Season: "2", Episode: "1"}, true},
+		{"kitsu:1376", idmap.Query{IMDb: "tt0112159"}, true},
+		{"tmdb:1399:1:2", idmap.Query{IMDb: "tt0944947", Season: "1", Episode: "2"}, true},
+		{"kitsu:1", idmap.Query{}, false},
+		{"tmdb:1:1:2", idmap.Query{}, false},
+	}
+
+	for _, tt := range tests {
+		id, err := stremio.ParseVideoID(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, resolved := m.Resolve(id)
+		if got != tt.want || resolved != tt.resolved {
+			t.Errorf("Resolve(%s) = %+v, %t, want %+v, %t", tt.id, got, resolved, tt.want, tt.resolved)
+		}
+	}
+
+	if got, want := m.Schemes(), []stremio.IDScheme{stremio.SchemeIMDb, stremio.SchemeKitsu, stremio.SchemeTMDB}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Schemes() = %v, want %v", got, want)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []idmap.Entry
+	}{
+		{"episode ID", []idmap.Entry{{ID: "kitsu:7442:3", IMDb: "tt2560140"}}},
+		{"malformed ID", []idmap.Entry{{ID: "kitsu:abc", IMDb: "tt2560140"}}},
+		{"IMDb ID", []idmap.Entry{{ID: "tt0111161", IMDb: "tt0111161"}}},
+		{"malformed IMDb ID", []idmap.Entry{{ID: "kitsu:7442", IMDb: "2560140"}}},
+		{"negative season", []idmap.Entry{{ID: "kitsu:7442", IMDb: "tt2560140", Season: -1}}},
+		{"duplicate", []idmap.Entry{{ID: "kitsu:7442", IMDb: "tt2560140"}, {ID: "kitsu:7442", IMDb: "tt0112159"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := idmap.New(tt.entries); err == nil {
+			t.Errorf("New with %s succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	m, err := idmap.Load("")
+	if err != nil {
+		t.Fatalf("Load without a path: %v", err)
+	}
+	if got := m.Schemes(); !reflect.DeepEqual(got, []stremio.IDScheme{stremio.SchemeIMDb}) {
+		t.Errorf("Schemes() of an empty mapping = %v, want IMDb only", got)
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	m, err = idmap.Load(write("idmap.yaml", "- id: kitsu:7442\n  imdb: tt2560140\n  season: 1\n- id: tmdb:1399\n  imdb: tt0944947\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if m.Len() != 2 {
+		t.Errorf("Load read %d entries, want 2", m.Len())
+	}
+
+	if _, err := idmap.Load(write("empty.yaml", "")); err != nil {
+		t.Errorf("Load of an empty file: %v", err)
+	}
+	for name, content := range map[string]string{
+		"unknown.yaml":   "- id: kitsu:7442\n  imdb: tt2560140\n  offset: 1\n",
+		"malformed.yaml": "- id: [kitsu:7442\n",
+		"invalid.yaml":   "- id: kitsu:7442\n  imdb: 2560140\n",
+	} {
+		if _, err := idmap.Load(write(name, content)); err == nil {
+			t.Errorf("Load of %s succeeded, want an error", name)
+		}
+	}
+	if _, err := idmap.Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("Load of a missing file succeeded, want an error")
+	}
+}
@@ -0,0 +1,128 @@
+// Package languages maps the language names Titlovi.com uses to ISO codes, scripts and display names.
+package languages
+
+import "strings"
+
+// Script is the ISO 15924 code of the script subtitles are written in.
+type Script string
+
+const (
+	ScriptLatin    Script = "Latn"
+	ScriptCyrillic Script = "Cyrl"
+	ScriptUnknown  Script = ""
+)
+
+// Undetermined is the ISO 639-2 code for languages that are not known.
+const Undetermined = "und"
+
+// Language is a language Titlovi.com has subtitles in.
+type Language struct {
+	Name         string            // The name Titlovi.com uses, e.g. "Cirilica".
+	Code         string            // The ISO 639-2/B code, e.g. "srp", which is what Stremio groups subtitles by.
+	Code1        string            // The ISO 639-1 code, e.g. "sr", or empty if there is none.
+	Script       Script            // The script the subtitles are written in.
+	DisplayNames map[string]string // Names to show users, by the ISO 639-1 code of their language.
+}
+
+// Known reports whether the language is in the registry, rather than a fallback for an unknown name.
+func (l Language) Known() bool {
+	return l.Code != Undetermined
+}
+
+// DisplayName returns the name of the language in the locale, an ISO 639-1 code, falling back to English and
+// then to the name Titlovi.com uses.
+func (l Language) DisplayName(locale string) string {
+	if name, ok := l.DisplayNames[strings.ToLower(locale)]; ok {
+		return name
+	}
+	if name, ok := l.DisplayNames["en"]; ok {
+		return name
+	}
+	return l.Name
+}
+
+// Registry holds the languages known by name.
+type Registry struct {
+	languages []Language
+	byName    map[string]int // Indexes into languages, by lowercased name.
+}
+
+// NewRegistry creates a Registry holding the languages, in the order given.
+func NewRegistry(languages ...Language) *Registry {
+	r := &Registry{byName: make(map[string]int, len(languages))}
+	for _, l := range languages {
+		r.byName[strings.ToLower(l.Name)] = len(r.languages)
+		r.languages = append(r.languages, l)
+	}
+	return r
+}
+
+// Lookup returns the language with the name, matched case-insensitively, and whether it is known.
+func (r *Registry) Lookup(name string) (Language, bool) {
+	i, ok := r.byName[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Language{}, false
+	}
+	return r.languages[i], true
+}
+
+// Resolve returns the language with the name. Unknown names resolve to an undetermined language keeping the
+// name, so that subtitles in languages added to Titlovi.com later are still served.
+func (r *Registry) Resolve(name string) Language {
+	if l, ok := r.Lookup(name); ok {
+		return l
+	}
+	return Language{Name: name, Code: Undetermined, Script: ScriptUnknown}
+}
+
+// All returns every known language, in registry order.
+func (r *Registry) All() []Language {
+	return append([]Language(nil), r.languages...)
+}
+
+// Canonical returns the names as Titlovi.com spells them, keeping unknown names as they are.
+func (r *Registry) Canonical(names []string) []string {
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		canonical = append(canonical, r.Resolve(strings.TrimSpace(name)).Name)
+	}
+	return canonical
+}
+
+// Rank returns the position of the language in the preferred names, or the number of preferred names if it
+// is not among them, so that preferred languages sort first.
+func (r *Registry) Rank(name string, preferred []string) int {
+	l := r.Resolve(name)
+	for i, p := range preferred {
+		if strings.EqualFold(r.Resolve(p).Name, l.Name) {
+			return i
+		}
+	}
+	return len(preferred)
+}
+
+// Default holds the languages Titlovi.com has subtitles in.
+var Default = NewRegistry(
+	Language{Name: "Bosanski", Code: "bos", Code1: "bs", Script: ScriptLatin, DisplayNames: map[string]string{
+		"en": "Bosnian", "bs": "Bosanski", "hr": "Bosanski", "sr": "Bosanski",
+	}},
+	Language{Name: "Hrvatski", Code: "hrv", Code1: "hr", Script: ScriptLatin, DisplayNames: map[string]string{
+		"en": "Croatian", "bs": "Hrvatski", "hr": "Hrvatski", "sr": "Hrvatski",
+	}},
+	Language{Name: "Srpski", Code: "srp", Code1: "sr", Script: ScriptLatin, DisplayNames: map[string]string{
+		"en": "Serbian (Latin)", "bs": "Srpski (latinica)", "hr": "Srpski (latinica)", "sr": "Srpski (latinica)",
+	}},
+	// Titlovi.com lists Serbian subtitles in Cyrillic as a language of their own.
+	Language{Name: "Cirilica", Code: "srp", Code1: "sr", Script: ScriptCyrillic, DisplayNames: map[string]string{
+		"en": "Serbian (Cyrillic)", "bs": "Srpski (ćirilica)", "hr": "Srpski (ćirilica)", "sr": "Srpski (ćirilica)",
+	}},
+	Language{Name: "English", Code: "eng", Code1: "en", Script: ScriptLatin, DisplayNames: map[string]string{
+		"en": "English", "bs": "Engleski", "hr": "Engleski", "sr": "Engleski",
+	}},
+	Language{Name: "Makedonski", Code: "mac", Code1: "mk", Script: ScriptCyrillic, DisplayNames: map[string]string{
+		"en": "Macedonian", "bs": "Makedonski", "hr": "Makedonski", "sr": "Makedonski",
+	}},
+	Language{Name: "Slovenski", Code: "slv", Code1: "sl", Script: ScriptLatin, DisplayNames: map[string]string{
+		"en": "Slovenian", "bs": "Slovenački", "hr": "Slovenski", "sr": "Slovenački",
+	}},
+)
@@ -0,0 +1,109 @@
+package languages_test
+
+import (
+	"go-titlovi/internal/languages"
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		code1  string
+		script languages.Script
+		known  bool
+	}{
+		{"Bosanski", "bos", "bs", languages.ScriptLatin, true},
+		{"Hrvatski", "hrv", "hr", languages.ScriptLatin, true},
+		{"Srpski", "srp", "sr", languages.ScriptLatin, true},
+		{"Cirilica", "srp", "sr", languages.ScriptCyrillic, true},
+		{"English", "eng", "en", languages.ScriptLatin, true},
+		{"Makedonski", "mac", "mk", languages.ScriptCyrillic, true},
+		{"Slovenski", "slv", "sl", languages.ScriptLatin, true},
+		{" sRPSKI ", "srp", "sr", languages.ScriptLatin, true},
+		{"Klingonski", languages.Undetermined, "", languages.ScriptUnknown, false},
+		{"", languages.Undetermined, "", languages.ScriptUnknown, false},
+	}
+
+	for _, tt := range tests {
+		l := languages.Default.Resolve(tt.name)
+		if l.Code != tt.code || l.Code1 != tt.code1 || l.Script != tt.script || l.Known() != tt.known {
+			t.Errorf("Resolve(%q) = %s/%s in %q (known %t), want %s/%s in %q (known %t)",
+				tt.name, l.Code, l.Code1, l.Script, l.Known(), tt.code, tt.code1, tt.script, tt.known)
+		}
+		if _, ok := languages.Default.Lookup(tt.name); ok != tt.known {
+			t.Errorf("Lookup(%q) known %t, want %t", tt.name, ok, tt.known)
+		}
+	}
+
+	// Unknown names are kept, so that subtitles in languages added to Titlovi.com later can still be labelled.
+	if l := languages.Default.Resolve("Klingonski"); l.Name != "Klingonski" || l.DisplayName("bs") != "Klingonski" {
+		t.Errorf("Resolve(Klingonski) = %+v, want the name kept", l)
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	cyrillic := languages.Default.Resolve("Cirilica")
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", "Serbian (Cyrillic)"},
+		{"sr", "Srpski (ćirilica)"},
+		{"HR", "Srpski (ćirilica)"},
+		{"de", "Serbian (Cyrillic)"},
+		{"", "Serbian (Cyrillic)"},
+	}
+	for _, tt := range tests {
+		if got := cyrillic.DisplayName(tt.locale); got != tt.want {
+			t.Errorf("DisplayName(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+
+	if got := (languages.Language{Name: "Esperanto"}).DisplayName("en"); got != "Esperanto" {
+		t.Errorf("DisplayName without display names = %q, want the name", got)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	got := languages.Default.Canonical([]string{" srpski", "CIRILICA", "Klingonski"})
+	if want := []string{"Srpski", "Cirilica", "Klingonski"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Canonical = %q, want %q", got, want)
+	}
+}
+
+func TestRank(t *testing.T) {
+	preferred := []string{"cirilica", "Hrvatski"}
+
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"Cirilica", 0},
+		{"hrvatski", 1},
+		// Serbian in Latin is a language of its own, even though it shares its code with Cyrillic.
+		{"Srpski", 2},
+		{"English", 2},
+		{"Klingonski", 2},
+	}
+	for _, tt := range tests {
+		if got := languages.Default.Rank(tt.name, preferred); got != tt.want {
+			t.Errorf("Rank(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	all := languages.Default.All()
+	if len(all) != 7 || all[0].Name != "Bosanski" || all[6].Name != "Slovenski" {
+		t.Fatalf("All() = %+v, want the seven languages in registry order", all)
+	}
+
+	// The registry is not changed through the returned slice.
+	all[0].Name = "Changed"
+	if languages.Default.All()[0].Name != "Bosanski" {
+		t.Error("changing the languages returned by All changed the registry")
+	}
+}
@@ -1,17 +1,92 @@
+// Package logger sets up structured logging with log/slog and carries request IDs through contexts.
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"go-titlovi/internal/tracing"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
 )
 
-var LogInfo *log.Logger
-var LogError *log.Logger
-var LogFatal *log.Logger
+// RequestIDHeader is the header a request ID is taken from and returned in.
+const RequestIDHeader = "X-Request-ID"
 
-// InitLoggers initializes logger for info, error and fatal messages.
-func InitLoggers() {
-	LogInfo = log.New(os.Stdout, "[INFO] ", log.Ldate|log.Ltime|log.LUTC)
-	LogError = log.New(os.Stdout, "[ERROR] ", log.Ldate|log.Ltime|log.LUTC)
-	LogFatal = log.New(os.Stdout, "[FATAL] ", log.Ldate|log.Ltime|log.Llongfile|log.LUTC)
+// level is the minimum level logged, which can be changed at runtime with SetLevel.
+var level = new(slog.LevelVar)
+
+type requestIDContextKey struct{}
+
+// Init sets the default slog logger to write to stdout in the format, either "text" or "json".
+func Init(format string) {
+	slog.SetDefault(slog.New(newHandler(os.Stdout, format)))
+}
+
+// newHandler creates the handler for the format, which adds the request ID and trace from the context to every record.
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+
+	if strings.EqualFold(format, "json") {
+		return &contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return &contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+// ParseLevel parses a level name, one of "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// SetLevel sets the minimum level to log. Unknown levels are ignored.
+func SetLevel(s string) {
+	if l, err := ParseLevel(s); err == nil {
+		level.Set(l)
+	}
+}
+
+// Fatal logs the message with the arguments as an error and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// WithRequestID returns a context carrying the request ID, which is then added to every record logged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestID returns the request ID set with WithRequestID, or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// contextHandler is a slog.Handler adding the request ID and the current span from the context to every record,
+// so that logs can be correlated with traces.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() && sc.Sampled {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID.String()), slog.String("span_id", sc.SpanID.String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
 }
@@ -0,0 +1,144 @@
+// Package metadata provides the titles and years of items, which Titlovi.com is searched by when it has no
+// subtitles linked to their IMDb IDs.
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-titlovi/internal/stremio"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Meta describes an item well enough to search for it by name.
+type Meta struct {
+	Title string `yaml:"title"`
+	Year  int    `yaml:"year"` // Zero if not known.
+	Type  string `yaml:"type"` // The Stremio type, e.g. "movie" or "series". Empty if not known.
+}
+
+// Entry is the metadata of the item with the Stremio ID, as read from a metadata file.
+type Entry struct {
+	ID   string `yaml:"id"` // The ID of the item without season or episode, e.g. "tt0076276" or "kitsu:1376".
+	Meta `yaml:",inline"`
+}
+
+// Catalog holds the metadata of items by ID.
+type Catalog struct {
+	entries map[string]Meta
+}
+
+// New creates a Catalog from the entries.
+func New(entries []Entry) (*Catalog, error) {
+	c := &Catalog{entries: make(map[string]Meta, len(entries))}
+
+	var errs []error
+	for i, e := range entries {
+		switch {
+		case e.ID == "" || strings.Count(e.ID, ":") > 1:
+			errs = append(errs, fmt.Errorf("entry %d: id %q is not the ID of an item", i+1, e.ID))
+		case strings.TrimSpace(e.Title) == "":
+			errs = append(errs, fmt.Errorf("entry %d: title must not be empty", i+1))
+		case e.Year < 0:
+			errs = append(errs, fmt.Errorf("entry %d: year must not be negative", i+1))
+		}
+		c.entries[e.ID] = e.Meta
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid metadata:\n%w", errors.Join(errs...))
+	}
+	return c, nil
+}
+
+// Load reads a Catalog from a YAML file holding a list of entries. An empty path loads an empty catalog.
+func Load(path string) (*Catalog, error) {
+	if path == "" {
+		return New(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	var entries []Entry
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse metadata %s: %w", path, err)
+	}
+
+	c, err := New(entries)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Len returns the number of items with metadata.
+func (c *Catalog) Len() int {
+	return len(c.entries)
+}
+
+// Schemes returns the ID schemes of the items with metadata.
+func (c *Catalog) Schemes() []stremio.IDScheme {
+	var schemes []stremio.IDScheme
+	for _, s := range stremio.Schemes {
+		for id := range c.entries {
+			if strings.HasPrefix(id, s.Prefix) && !slices.Contains(schemes, s) {
+				schemes = append(schemes, s)
+			}
+		}
+	}
+	return schemes
+}
+
+// Lookup returns the metadata of the item with the ID, and whether there is any.
+func (c *Catalog) Lookup(id string) (Meta, bool) {
+	m, ok := c.entries[id]
+	return m, ok
+}
+
+var (
+	// yearPattern matches a release year, which names in file names are followed by.
+	yearPattern = regexp.MustCompile(`[(\[]?\b(19[0-9]{2}|20[0-9]{2})\b[)\]]?`)
+	// episodePattern matches an episode marker such as S01E02, which names of series are followed by.
+	episodePattern = regexp.MustCompile(`(?i)\bS[0-9]{1,2}E[0-9]{1,3}\b`)
+	// separators are replaced by spaces in names taken from file names.
+	separators = strings.NewReplacer(".", " ", "_", " ")
+)
+
+// FromFilename derives metadata from the name of a video file, such as "Ko.to.tamo.peva.1980.1080p.mkv" or
+// "Vruć.vetar.S01E02.avi", and reports whether a title could be found.
+func FromFilename(filename string) (Meta, bool) {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = separators.Replace(name)
+
+	var m Meta
+	end := len(name)
+
+	if loc := episodePattern.FindStringIndex(name); loc != nil {
+		m.Type = "series"
+		end = loc[0]
+	}
+	// The last year is taken, since titles can start with or contain years themselves, as in "1917 2019".
+	matches := yearPattern.FindAllStringSubmatchIndex(name[:end], -1)
+	if n := len(matches); n > 0 && matches[n-1][0] > 0 {
+		loc := matches[n-1]
+		m.Year, _ = strconv.Atoi(name[loc[2]:loc[3]])
+		end = loc[0]
+	}
+
+	m.Title = strings.Join(strings.Fields(name[:end]), " ")
+	return m, m.Title != ""
+}
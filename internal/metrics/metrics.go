@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+// Default is the registry the metrics of the addon are registered in.
+var Default = NewRegistry()
+
+const namespace = "titlovi_addon"
+
+var (
+	HTTPRequests = Default.NewCounterVec(namespace+"_http_requests_total",
+		"Requests handled, by route, method and status.", "route", "method", "status")
+	HTTPRequestDuration = Default.NewHistogramVec(namespace+"_http_request_duration_seconds",
+		"Time taken to handle requests, by route and method.", DefBuckets, "route", "method")
+
+	CacheLookups = Default.NewCounterVec(namespace+"_cache_lookups_total",
+		"Cache lookups, by cache and result, which is one of hit, miss or stale.", "cache", "result")
+
+	UpstreamRequestDuration = Default.NewHistogramVec(namespace+"_upstream_request_duration_seconds",
+		"Time taken by single requests toward Titlovi.com, by endpoint and status.", DefBuckets, "endpoint", "status")
+	UpstreamErrors = Default.NewCounterVec(namespace+"_upstream_errors_total",
+		"Failed operations toward Titlovi.com after retries, by endpoint and error class.", "endpoint", "class")
+
+	RateLimitedRequests = Default.NewCounterVec(namespace+"_rate_limited_requests_total",
+		"Requests rejected by rate limiting, by policy.", "policy")
+
+	ArchiveExtractionFailures = Default.NewCounterVec(namespace+"_archive_extraction_failures_total",
+		"Downloaded subtitles that could not be extracted from their archive.")
+)
+
+func init() {
+	start := time.Now()
+
+	// Counters without labels are exposed from the start, so that they do not appear out of nowhere.
+	ArchiveExtractionFailures.Add(0)
+
+	Default.NewGaugeFunc(namespace+"_uptime_seconds", "Time since the addon started.", func() float64 {
+		return time.Since(start).Seconds()
+	})
+	Default.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	Default.NewGaugeFunc("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", func() float64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return float64(m.HeapAlloc)
+	})
+}
+
+// Since returns the seconds elapsed since start, as observed by histograms.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// ObserveLoginTokens exposes how many Titlovi.com login tokens are active, as returned by fn.
+func ObserveLoginTokens(fn func() int) {
+	Default.NewGaugeFunc(namespace+"_login_tokens_active", "Titlovi.com login tokens held that have not expired.", func() float64 {
+		return float64(fn())
+	})
+}
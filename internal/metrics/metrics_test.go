@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"go-titlovi/internal/metrics"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns what the registry exposes.
+func scrape(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", got)
+	}
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCounterVec(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests\nhandled, by \\ route.", "route", "status")
+
+	c.Inc("/b", "200")
+	c.Inc("/a", "200")
+	c.Add(2.5, "/a", "200")
+	c.Add(-1, "/a", "200")
+	c.Inc(`/"quoted"`+"\n", "500")
+
+	want := `# HELP requests_total Requests\nhandled, by \\ route.
+# TYPE requests_total counter
+requests_total{route="/\"quoted\"\n",status="500"} 1
+requests_total{route="/a",status="200"} 3.5
+requests_total{route="/b",status="200"} 1
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("scraped:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogramVec("duration_seconds", "Time taken.", []float64{1, 0.1}, "route")
+
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(5, "/a")
+
+	want := `# HELP duration_seconds Time taken.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 2
+duration_seconds_bucket{route="/a",le="1"} 3
+duration_seconds_bucket{route="/a",le="+Inf"} 4
+duration_seconds_sum{route="/a"} 5.65
+duration_seconds_count{route="/a"} 4
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("scraped:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := metrics.NewRegistry()
+	value := 3.0
+	r.NewGaugeFunc("tokens", "Tokens held.", func() float64 { return value })
+	r.NewCounterVec("unlabelled_total", "Unlabelled.").Add(0)
+
+	if got := scrape(t, r); !strings.Contains(got, "\ntokens 3\n") || !strings.Contains(got, "\nunlabelled_total 0\n") {
+		t.Errorf("scraped:\n%s\nwant the gauge at 3 and the counter at 0", got)
+	}
+	value = 4
+	if got := scrape(t, r); !strings.Contains(got, "\ntokens 4\n") {
+		t.Errorf("scraped:\n%s\nwant the gauge read again", got)
+	}
+}
+
+func TestLabelMismatchPanics(t *testing.T) {
+	c := metrics.NewRegistry().NewCounterVec("requests_total", "Requests.", "route")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with too many label values did not panic")
+		}
+	}()
+	c.Inc("/a", "200")
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	got := scrape(t, metrics.Default)
+	for _, name := range []string{
+		"titlovi_addon_http_requests_total",
+		"titlovi_addon_uptime_seconds",
+		"titlovi_addon_archive_extraction_failures_total",
+		"go_goroutines",
+	} {
+		if !strings.Contains(got, "# TYPE "+name+" ") {
+			t.Errorf("default registry does not expose %s", name)
+		}
+	}
+}
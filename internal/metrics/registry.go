@@ -0,0 +1,124 @@
+// Package metrics implements the few Prometheus metric types the addon needs and exposes them in the
+// Prometheus text format, without depending on the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is a metric that can be written in the Prometheus text format.
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds the metrics exposed by its handler.
+type Registry struct {
+	mtx        sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric to the registry.
+func (r *Registry) register(c collector) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Handler returns an http.Handler writing every metric in the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mtx.Lock()
+		collectors := slices.Clone(r.collectors)
+		r.mtx.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		bw := bufio.NewWriter(w)
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		_ = bw.Flush()
+	})
+}
+
+// desc holds what every metric has in common.
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric.
+func (d *desc) writeHeader(w io.Writer, typ string) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, typ)
+}
+
+// checkLabels panics if the number of label values does not match the labels of the metric, as that is a
+// programming error.
+func (d *desc) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+// formatLabels formats the labels with their values, plus any extra label, as {name="value",...}.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, escape.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escape.Replace(extra[i+1])))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatFloat formats a sample value the way Prometheus expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of the map in order, so that the output is stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
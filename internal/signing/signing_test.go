@@ -0,0 +1,138 @@
+package signing_test
+
+import (
+	"errors"
+	"go-titlovi/internal/signing"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := signing.NewSigner([]byte("secret"), time.Hour, 10*time.Minute)
+	now := time.Date(2024, 5, 1, 12, 34, 56, 0, time.UTC)
+
+	// The expiry is counted from the start of the window, so URLs signed within a window are identical.
+	params := s.Sign("1", "10", "", now)
+	if got, want := params.Get(signing.ExpiresParam), "1714570200"; got != want {
+		t.Errorf("expires = %s, want %s, an hour after 12:30", got, want)
+	}
+	if later := s.Sign("1", "10", "", now.Add(4*time.Minute)); later.Encode() != params.Encode() {
+		t.Errorf("signed %q later within the window, want %q", later.Encode(), params.Encode())
+	}
+	if next := s.Sign("1", "10", "", now.Add(6*time.Minute)); next.Encode() == params.Encode() {
+		t.Errorf("signed %q in the next window, want it to differ", next.Encode())
+	}
+	if params.Has(signing.UserParam) {
+		t.Errorf("URL signed without a user carries one: %q", params.Encode())
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want error
+	}{
+		{"when signed", now, nil},
+		{"at the expiry", time.Unix(1714570200, 0), nil},
+		{"after the expiry", time.Unix(1714570201, 0), signing.ErrExpired},
+	}
+	for _, tt := range tests {
+		if err := s.Verify("1", "10", params, tt.now); !errors.Is(err, tt.want) {
+			t.Errorf("Verify %s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	s := signing.NewSigner([]byte("secret"), time.Hour, time.Minute)
+	now := time.Now()
+	signed := s.Sign("1", "10", "user", now)
+
+	tests := []struct {
+		name         string
+		resourceType string
+		resourceId   string
+		tamper       func(url.Values)
+		want         error
+	}{
+		{"untouched", "1", "10", func(url.Values) {}, nil},
+		{"type", "2", "10", func(url.Values) {}, signing.ErrInvalidSignature},
+		{"ID", "1", "11", func(url.Values) {}, signing.ErrInvalidSignature},
+		{"user", "1", "10", func(p url.Values) { p.Set(signing.UserParam, "other") }, signing.ErrInvalidSignature},
+		{"removed user", "1", "10", func(p url.Values) { p.Del(signing.UserParam) }, signing.ErrInvalidSignature},
+		{"extended expiry", "1", "10", func(p url.Values) { p.Set(signing.ExpiresParam, "99999999999") }, signing.ErrInvalidSignature},
+		{"malformed expiry", "1", "10", func(p url.Values) { p.Set(signing.ExpiresParam, "soon") }, signing.ErrInvalidSignature},
+		{"signature", "1", "10", func(p url.Values) { p.Set(signing.SignatureParam, "AAAA") }, signing.ErrInvalidSignature},
+		{"missing signature", "1", "10", func(p url.Values) { p.Del(signing.SignatureParam) }, signing.ErrMissingSignature},
+		{"missing expiry", "1", "10", func(p url.Values) { p.Del(signing.ExpiresParam) }, signing.ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		params, _ := url.ParseQuery(signed.Encode())
+		tt.tamper(params)
+		if err := s.Verify(tt.resourceType, tt.resourceId, params, now); !errors.Is(err, tt.want) {
+			t.Errorf("Verify with tampered %s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Tampered URLs are reported as such even once expired.
+	params, _ := url.ParseQuery(signed.Encode())
+	if err := s.Verify("2", "10", params, now.Add(24*time.Hour)); !errors.Is(err, signing.ErrInvalidSignature) {
+		t.Errorf("Verify expired with tampered type: got %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestTypeSeparation(t *testing.T) {
+	s := signing.NewSigner([]byte("secret"), time.Hour, time.Minute)
+	now := time.Now()
+
+	// Values are separated, so that moving a character between the type and the ID changes the signature.
+	// Signatures of one type must never verify for another.
+	if err := s.Verify("12", "3", s.Sign("1", "23", "", now), now); !errors.Is(err, signing.ErrInvalidSignature) {
+		t.Errorf("Verify with the type and ID split differently: got %v, want ErrInvalidSignature", err)
+	}
+	if err := s.Verify("csrf", "10", s.Sign("1", "10", "", now), now); !errors.Is(err, signing.ErrInvalidSignature) {
+		t.Errorf("Verify with another type: got %v, want ErrInvalidSignature", err)
+	}
+
+	other := signing.NewSigner([]byte("other"), time.Hour, time.Minute)
+	if err := other.Verify("1", "10", s.Sign("1", "10", "", now), now); !errors.Is(err, signing.ErrInvalidSignature) {
+		t.Errorf("Verify with another secret: got %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	s := signing.NewSigner([]byte("secret"), time.Hour, time.Minute)
+	csrf := s.Derive("csrf")
+	now := time.Now()
+
+	if err := csrf.Verify("csrf", "nonce", s.Derive("csrf").Sign("csrf", "nonce", "", now), now); err != nil {
+		t.Errorf("Verify with a signer derived for the same purpose: %v", err)
+	}
+	for name, other := range map[string]*signing.Signer{"the parent": s, "another purpose": s.Derive("other")} {
+		if err := other.Verify("csrf", "nonce", csrf.Sign("csrf", "nonce", "", now), now); !errors.Is(err, signing.ErrInvalidSignature) {
+			t.Errorf("Verify of a derived signature by %s: got %v, want ErrInvalidSignature", name, err)
+		}
+		if err := csrf.Verify("1", "10", other.Sign("1", "10", "", now), now); !errors.Is(err, signing.ErrInvalidSignature) {
+			t.Errorf("Verify of a signature by %s with a derived signer: got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestUserID(t *testing.T) {
+	s := signing.NewSigner([]byte("secret"), time.Hour, time.Minute)
+
+	id := s.UserID("user")
+	if id != s.UserID("user") {
+		t.Error("UserID is not stable")
+	}
+	if id == s.UserID("other") {
+		t.Error("UserID is the same for different users")
+	}
+	if id == signing.NewSigner([]byte("other"), time.Hour, time.Minute).UserID("user") {
+		t.Error("UserID does not depend on the secret")
+	}
+	if url.QueryEscape(id) != id || id == "user" {
+		t.Errorf("UserID = %q, want an opaque value safe in URLs", id)
+	}
+}
@@ -0,0 +1,250 @@
+// Package stremio serves the Stremio addon protocol: it validates manifests, generates the routes of the
+// resources they declare and parses the arguments of requests for them.
+package stremio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ConfigVar is the name of the route variable holding the user configuration of config-prefixed routes.
+const ConfigVar = "userConfig"
+
+// Args are the arguments of a resource request.
+type Args[E any] struct {
+	Type   string // The type of the item, e.g. "movie".
+	ID     string // The ID of the item, e.g. "tt0111161" or "tt0903747:1:2".
+	Extra  E      // The extra arguments, if the request had any.
+	Config string // The encoded user configuration, if the request was config-prefixed.
+}
+
+// Handler handles requests for a resource with parsed arguments. The type and ID have been checked
+// against the manifest.
+type Handler[E any] func(w http.ResponseWriter, r *http.Request, args Args[E])
+
+// Route is a route of the Stremio addon protocol.
+type Route struct {
+	Resource   Resource
+	Path       string // The path template, e.g. "/{userConfig}/subtitles/{type}/{id}.json".
+	Configured bool   // Whether the path is prefixed with the user configuration.
+	Handler    http.Handler
+}
+
+// Builder builds an Addon from a manifest and handlers for the resources it declares.
+type Builder struct {
+	manifest     Manifest
+	configured   *Manifest
+	adapt        func(r *http.Request, m Manifest) Manifest
+	handlers     map[Resource]func(a *Addon) http.Handler
+	cacheControl string
+}
+
+// NewBuilder creates a Builder for an addon with the manifest.
+func NewBuilder(manifest Manifest) *Builder {
+	return &Builder{manifest: manifest, handlers: make(map[Resource]func(a *Addon) http.Handler)}
+}
+
+// Catalog sets the handler for catalog requests.
+func (b *Builder) Catalog(h Handler[CatalogExtra]) *Builder {
+	b.handlers[ResourceCatalog] = adapt(ResourceCatalog, h)
+	return b
+}
+
+// Meta sets the handler for meta requests.
+func (b *Builder) Meta(h Handler[NoExtra]) *Builder {
+	b.handlers[ResourceMeta] = adapt(ResourceMeta, h)
+	return b
+}
+
+// Stream sets the handler for stream requests.
+func (b *Builder) Stream(h Handler[NoExtra]) *Builder {
+	b.handlers[ResourceStream] = adapt(ResourceStream, h)
+	return b
+}
+
+// Subtitles sets the handler for subtitles requests.
+func (b *Builder) Subtitles(h Handler[SubtitlesExtra]) *Builder {
+	b.handlers[ResourceSubtitles] = adapt(ResourceSubtitles, h)
+	return b
+}
+
+// ConfiguredManifest sets the manifest served to config-prefixed requests, e.g. to describe the addon as
+// configured. It defaults to the manifest without configuration being required.
+func (b *Builder) ConfiguredManifest(m Manifest) *Builder {
+	b.configured = &m
+	return b
+}
+
+// ManifestCacheControl sets the Cache-Control header manifests are served with.
+func (b *Builder) ManifestCacheControl(value string) *Builder {
+	b.cacheControl = value
+	return b
+}
+
+// AdaptManifest sets a function adapting the manifest served to a request, e.g. to describe the addon in the
+// language of the user. Since routes are generated from the built manifests, the adapted manifest must declare
+// the same resources, types and ID prefixes.
+func (b *Builder) AdaptManifest(adapt func(r *http.Request, m Manifest) Manifest) *Builder {
+	b.adapt = adapt
+	return b
+}
+
+// Build validates the manifest and checks that every declared resource has a handler and every handler
+// a declared resource.
+func (b *Builder) Build() (*Addon, error) {
+	configured := b.manifest
+	configured.BehaviourHints.ConfigurationRequired = false
+	if b.configured != nil {
+		configured = *b.configured
+	}
+
+	var errs []error
+	if err := b.manifest.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if b.configured != nil {
+		if err := configured.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("configured manifest: %w", err))
+		}
+		if configured.Id != b.manifest.Id {
+			errs = append(errs, fmt.Errorf("configured manifest id %q must match id %q", configured.Id, b.manifest.Id))
+		}
+	}
+	for _, resource := range resources {
+		_, handled := b.handlers[resource]
+		switch declared := b.manifest.declares(resource); {
+		case declared && !handled:
+			errs = append(errs, fmt.Errorf("resource %s is declared but has no handler", resource))
+		case handled && !declared:
+			errs = append(errs, fmt.Errorf("resource %s has a handler but is not declared", resource))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("build addon: %w", errors.Join(errs...))
+	}
+
+	a := &Addon{manifest: b.manifest, configured: configured, adapt: b.adapt, cacheControl: b.cacheControl}
+	for _, resource := range resources {
+		if newHandler, ok := b.handlers[resource]; ok {
+			a.handlers = append(a.handlers, resourceHandler{resource, newHandler(a)})
+		}
+	}
+	return a, nil
+}
+
+type resourceHandler struct {
+	resource Resource
+	handler  http.Handler
+}
+
+// Addon serves the Stremio addon protocol for a manifest.
+type Addon struct {
+	manifest     Manifest
+	configured   Manifest
+	adapt        func(r *http.Request, m Manifest) Manifest
+	handlers     []resourceHandler
+	cacheControl string
+}
+
+// Manifest returns the manifest of the addon.
+func (a *Addon) Manifest() Manifest {
+	return a.manifest
+}
+
+// Routes returns every route of the protocol for the addon. Unprefixed resource routes are left out when the
+// addon requires configuration, and config-prefixed routes when it is not configurable.
+func (a *Addon) Routes() []Route {
+	hints := a.manifest.BehaviourHints
+	var routes []Route
+
+	add := func(resource Resource, suffix string, h http.Handler) {
+		if resource == ResourceManifest || !hints.ConfigurationRequired {
+			routes = append(routes, Route{Resource: resource, Path: "/" + suffix, Handler: h})
+		}
+		if hints.Configurable {
+			routes = append(routes, Route{Resource: resource, Path: "/{" + ConfigVar + "}/" + suffix, Configured: true, Handler: h})
+		}
+	}
+
+	add(ResourceManifest, "manifest.json", http.HandlerFunc(a.manifestHandler))
+	for _, rh := range a.handlers {
+		add(rh.resource, string(rh.resource)+"/{type}/{id}.json", rh.handler)
+		add(rh.resource, string(rh.resource)+"/{type}/{id}/{extraArgs}.json", rh.handler)
+	}
+
+	return routes
+}
+
+// Register adds every route of the addon to the router, each wrapped by wrap, e.g. to authenticate
+// config-prefixed routes or to rate limit resources. wrap may be nil.
+func (a *Addon) Register(r *mux.Router, wrap func(route Route) http.Handler) {
+	for _, route := range a.Routes() {
+		h := route.Handler
+		if wrap != nil {
+			h = wrap(route)
+		}
+		r.Handle(route.Path, h).Methods(http.MethodGet, http.MethodHead)
+	}
+}
+
+// manifestHandler serves the manifest. Config-prefixed requests are served the configured manifest, since
+// the user has configured the addon. Either is adapted to the request if the builder was given a function to.
+func (a *Addon) manifestHandler(w http.ResponseWriter, r *http.Request) {
+	manifest := a.manifest
+	if _, ok := mux.Vars(r)[ConfigVar]; ok {
+		manifest = a.configured
+	}
+	if a.adapt != nil {
+		manifest = a.adapt(r, manifest)
+	}
+
+	jsonResponse, err := json.Marshal(manifest)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "manifestHandler: failed to marshal json", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if a.cacheControl != "" {
+		w.Header().Set("Cache-Control", a.cacheControl)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(jsonResponse)
+}
+
+// adapt turns a typed handler into an http.Handler parsing its arguments from the route, which responds with
+// 404 to types and IDs the manifest does not declare and with 400 to malformed extra arguments.
+func adapt[E any](resource Resource, h Handler[E]) func(a *Addon) http.Handler {
+	return func(a *Addon) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			args := Args[E]{Type: vars["type"], ID: vars["id"], Config: vars[ConfigVar]}
+
+			if !a.manifest.accepts(args.Type, args.ID) {
+				slog.DebugContext(r.Context(), "adapt: type or ID not declared by the manifest", "resource", resource, "type", args.Type, "id", args.ID)
+				http.NotFound(w, r)
+				return
+			}
+
+			if _, ok := vars["extraArgs"]; ok {
+				// The escaped path is parsed, since decoding it first would break escaped ampersands in values.
+				extra := strings.TrimSuffix(path.Base(r.URL.EscapedPath()), ".json")
+				if err := ParseExtra(extra, &args.Extra); err != nil {
+					slog.InfoContext(r.Context(), "adapt: malformed extra arguments", "resource", resource, "error", err)
+					http.Error(w, "malformed extra arguments", http.StatusBadRequest)
+					return
+				}
+			}
+
+			h(w, r, args)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package stremio
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// NoExtra is the extra of resources that take no extra arguments.
+type NoExtra struct{}
+
+// SubtitlesExtra is the extra Stremio passes to subtitles requests, describing the video being played.
+type SubtitlesExtra struct {
+	VideoHash string `extra:"videoHash"` // OpenSubtitles hash of the video.
+	VideoSize int64  `extra:"videoSize"` // Size of the video in bytes.
+	Filename  string `extra:"filename"`  // Name of the video file.
+}
+
+// CatalogExtra is the extra Stremio passes to catalog requests.
+type CatalogExtra struct {
+	Search string `extra:"search"`
+	Genre  string `extra:"genre"`
+	Skip   int    `extra:"skip"`
+}
+
+// ParseExtra parses the extra arguments of a request, formatted like a URL query, into the struct pointed
+// to by dst. Fields are matched by their extra tag, and unknown arguments are ignored.
+//
+// Supported field types are strings, integers and booleans.
+func ParseExtra(extra string, dst any) error {
+	values, err := url.ParseQuery(extra)
+	if err != nil {
+		return fmt.Errorf("parse extra: %w", err)
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("parse extra: destination must be a pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("extra")
+		if key == "" || !values.Has(key) {
+			continue
+		}
+
+		value := values.Get(key)
+		switch f := v.Field(i); f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("parse extra %s: %w", key, err)
+			}
+			f.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("parse extra %s: %w", key, err)
+			}
+			f.SetBool(b)
+		default:
+			return fmt.Errorf("parse extra %s: unsupported field type %s", key, f.Type())
+		}
+	}
+
+	return nil
+}
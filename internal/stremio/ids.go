@@ -0,0 +1,84 @@
+package stremio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDScheme is a kind of ID Stremio identifies items by, recognized by its prefix.
+type IDScheme struct {
+	Name   string
+	Prefix string
+}
+
+var (
+	SchemeIMDb  = IDScheme{Name: "imdb", Prefix: "tt"}      // e.g. "tt0903747:1:2"
+	SchemeKitsu = IDScheme{Name: "kitsu", Prefix: "kitsu:"} // e.g. "kitsu:7442:3", where episodes are counted across seasons.
+	SchemeTMDB  = IDScheme{Name: "tmdb", Prefix: "tmdb:"}   // e.g. "tmdb:1399:1:2"
+)
+
+// Schemes are the ID schemes ParseVideoID understands.
+var Schemes = []IDScheme{SchemeIMDb, SchemeKitsu, SchemeTMDB}
+
+// IDPrefixes returns the prefixes of the schemes, as declared in manifests.
+func IDPrefixes(schemes ...IDScheme) []string {
+	prefixes := make([]string, 0, len(schemes))
+	for _, s := range schemes {
+		prefixes = append(prefixes, s.Prefix)
+	}
+	return prefixes
+}
+
+// VideoID is a parsed Stremio video ID.
+type VideoID struct {
+	Scheme  IDScheme
+	ID      string // The ID of the item without the season and episode, e.g. "tt0903747" or "kitsu:7442".
+	Season  string // Empty unless the scheme has seasons and the ID is of an episode.
+	Episode string // Empty unless the ID is of an episode.
+}
+
+// String formats the ID as Stremio does.
+func (v VideoID) String() string {
+	parts := []string{v.ID}
+	if v.Season != "" {
+		parts = append(parts, v.Season)
+	}
+	if v.Episode != "" {
+		parts = append(parts, v.Episode)
+	}
+	return strings.Join(parts, ":")
+}
+
+// ParseVideoID parses a Stremio video ID of any of the known schemes.
+func ParseVideoID(id string) (VideoID, error) {
+	var scheme IDScheme
+	for _, s := range Schemes {
+		if strings.HasPrefix(id, s.Prefix) {
+			scheme = s
+		}
+	}
+	if scheme.Prefix == "" {
+		return VideoID{}, fmt.Errorf("parse video ID %q: not a known ID", id)
+	}
+
+	rest := strings.TrimPrefix(id, scheme.Prefix)
+	parts := strings.Split(rest, ":")
+	for _, p := range parts {
+		if _, err := strconv.ParseUint(p, 10, 64); err != nil {
+			return VideoID{}, fmt.Errorf("parse video ID %q: not a known ID", id)
+		}
+	}
+
+	v := VideoID{Scheme: scheme, ID: scheme.Prefix + parts[0]}
+	switch {
+	case scheme == SchemeKitsu && len(parts) == 2:
+		v.Episode = parts[1]
+	case scheme != SchemeKitsu && len(parts) == 3:
+		v.Season, v.Episode = parts[1], parts[2]
+	case len(parts) != 1:
+		return VideoID{}, fmt.Errorf("parse video ID %q: unexpected number of parts", id)
+	}
+
+	return v, nil
+}
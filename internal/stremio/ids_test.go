@@ -0,0 +1,57 @@
+package stremio_test
+
+import (
+	"go-titlovi/internal/stremio"
+	"testing"
+)
+
+func TestParseVideoID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want stremio.VideoID
+	}{
+		{"tt0111161", stremio.VideoID{Scheme: stremio.SchemeIMDb, ID: "tt0111161"}},
+		{"tt0903747:1:2", stremio.VideoID{Scheme: stremio.SchemeIMDb, ID: "tt0903747", Season: "1", Episode: "2"}},
+		{"kitsu:7442", stremio.VideoID{Scheme: stremio.SchemeKitsu, ID: "kitsu:7442"}},
+		{"kitsu:7442:3", stremio.VideoID{Scheme: stremio.SchemeKitsu, ID: "kitsu:7442", Episode: "3"}},
+		{"tmdb:1399", stremio.VideoID{Scheme: stremio.SchemeTMDB, ID: "tmdb:1399"}},
+		{"tmdb:1399:1:2", stremio.VideoID{Scheme: stremio.SchemeTMDB, ID: "tmdb:1399", Season: "1", Episode: "2"}},
+	}
+
+	for _, tt := range tests {
+		got, err := stremio.ParseVideoID(tt.id)
+		if err != nil {
+			t.Errorf("ParseVideoID(%q): %v", tt.id, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseVideoID(%q) = %+v, want %+v", tt.id, got, tt.want)
+		}
+		if got.String() != tt.id {
+			t.Errorf("ParseVideoID(%q).String() = %q", tt.id, got.String())
+		}
+	}
+}
+
+func TestParseVideoIDMalformed(t *testing.T) {
+	for _, id := range []string{
+		"",
+		"tt",
+		"ttabc",
+		"tt0111161:",
+		"tt0903747:1",
+		"tt0903747:1:2:3",
+		"tt0903747:a:2",
+		"kitsu:",
+		"kitsu:7442:1:3",
+		"tmdb:1399:2",
+		"tmdb:-1",
+		"0111161",
+		"mal:5114",
+		"TT0111161",
+	} {
+		if got, err := stremio.ParseVideoID(id); err == nil {
+			t.Errorf("ParseVideoID(%q) = %+v, want an error", id, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package stremio
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Resource is a kind of request an addon can handle, as named by the Stremio addon protocol.
+type Resource string
+
+const (
+	ResourceManifest  Resource = "manifest"
+	ResourceCatalog   Resource = "catalog"
+	ResourceMeta      Resource = "meta"
+	ResourceStream    Resource = "stream"
+	ResourceSubtitles Resource = "subtitles"
+)
+
+// resources are the resources a manifest can declare, in the order routes are generated for them.
+var resources = []Resource{ResourceCatalog, ResourceMeta, ResourceStream, ResourceSubtitles}
+
+// semver matches the semantic versions Stremio requires manifests to have.
+var semver = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// Validate checks the manifest against the Stremio addon protocol and returns an error describing every
+// problem found.
+func (m *Manifest) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(m.Id != "" && !strings.ContainsAny(m.Id, " \t\n/"), "id %q must be a non-empty identifier without spaces or slashes", m.Id)
+	check(semver.MatchString(m.Version), "version %q must be a semantic version", m.Version)
+	check(m.Name != "", "name must not be empty")
+	check(m.Description != "", "description must not be empty")
+
+	check(len(m.Types) > 0, "types must not be empty")
+	for _, t := range m.Types {
+		check(t != "", "types must not contain empty types")
+	}
+
+	check(len(m.Resources) > 0, "resources must not be empty")
+	for _, r := range m.Resources {
+		check(slices.Contains(resources, Resource(r)), "resource %q is not one of catalog, meta, stream or subtitles", r)
+	}
+
+	check(m.Catalogs != nil, "catalogs must be set, even if empty")
+	check(len(m.Catalogs) == 0 || slices.Contains(m.Resources, string(ResourceCatalog)), "catalogs require the catalog resource")
+	for _, c := range m.Catalogs {
+		check(c.Id != "" && c.Type != "", "catalog %q must have an id and a type", c.Id)
+	}
+
+	for _, prefix := range m.IdPrefixes {
+		check(prefix != "", "idPrefixes must not contain empty prefixes")
+	}
+
+	check(m.Logo == "" || isHTTPURL(m.Logo), "logo %q must be an http(s) URL", m.Logo)
+	check(m.Background == "" || isHTTPURL(m.Background), "background %q must be an http(s) URL", m.Background)
+	_, err := mail.ParseAddress(m.ContactEmail)
+	check(m.ContactEmail == "" || err == nil, "contactEmail %q must be an email address", m.ContactEmail)
+
+	check(!m.BehaviourHints.ConfigurationRequired || m.BehaviourHints.Configurable, "behaviourHints.configurationRequired requires behaviourHints.configurable")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid manifest:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// declares reports whether the manifest declares the resource.
+func (m *Manifest) declares(resource Resource) bool {
+	return slices.Contains(m.Resources, string(resource))
+}
+
+// accepts reports whether the manifest declares the type and an ID prefix the ID has, if it restricts them.
+func (m *Manifest) accepts(mediaType, id string) bool {
+	if !slices.Contains(m.Types, mediaType) {
+		return false
+	}
+	if len(m.IdPrefixes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(m.IdPrefixes, func(prefix string) bool { return strings.HasPrefix(id, prefix) })
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -1,10 +1,5 @@
 package stremio
 
-type UserConfig struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
-
 type CatalogItem struct {
 	Type string `json:"type"`
 	Id   string `json:"id"`
@@ -36,5 +31,7 @@ type Manifest struct {
 	Catalogs       []CatalogItem  `json:"catalogs"`
 	IdPrefixes     []string       `json:"idPrefixes,omitempty"`
 	Logo           string         `json:"logo,omitempty"`
+	Background     string         `json:"background,omitempty"`
+	ContactEmail   string         `json:"contactEmail,omitempty"`
 	BehaviourHints BehaviourHints `json:"behaviourHints,omitempty"`
 }
@@ -0,0 +1,169 @@
+package titlovi
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Requests are let through.
+	BreakerOpen                         // Requests fail immediately.
+	BreakerHalfOpen                     // A limited number of requests are let through to probe whether the endpoint recovered.
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerConfig configures when a circuit breaker opens and how it recovers.
+//
+// A zero FailureThreshold disables the breaker.
+type BreakerConfig struct {
+	FailureThreshold    int           // How many consecutive failures open the breaker.
+	OpenTimeout         time.Duration // How long the breaker stays open before letting probe requests through.
+	HalfOpenMaxRequests int           // How many probe requests can be in flight while half-open.
+}
+
+// BreakerStatus is a snapshot of the state of the circuit breaker for an endpoint.
+type BreakerStatus struct {
+	Endpoint            Endpoint     `json:"endpoint"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+}
+
+// outcome is how a request let through by a circuit breaker turned out.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota // The endpoint responded properly.
+	outcomeFailure                // The endpoint failed or did not respond in time.
+	outcomeNeutral                // The request says nothing about the endpoint, e.g. it was never sent or cancelled by the caller.
+)
+
+// circuitBreaker stops requests toward an endpoint after it failed repeatedly, so that they fail
+// fast instead of waiting for retries and timeouts.
+type circuitBreaker struct {
+	endpoint Endpoint
+	config   BreakerConfig
+
+	mtx              sync.Mutex
+	state            BreakerState
+	failures         int
+	openedAt         time.Time
+	halfOpenInFlight int
+}
+
+// newCircuitBreaker creates a closed circuitBreaker for the endpoint.
+func newCircuitBreaker(endpoint Endpoint, config BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		endpoint: endpoint,
+		config:   config,
+	}
+}
+
+// allow checks whether a request can be made. If so, the returned function must be called with
+// the outcome of the request once it is done.
+func (b *circuitBreaker) allow() (func(outcome), error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return nil, ErrCircuitOpen
+		}
+		b.setStateLocked(BreakerHalfOpen)
+	}
+
+	probe := b.state == BreakerHalfOpen
+	if probe {
+		if b.halfOpenInFlight >= max(b.config.HalfOpenMaxRequests, 1) {
+			return nil, ErrCircuitOpen
+		}
+		b.halfOpenInFlight++
+	}
+
+	var once sync.Once
+	return func(o outcome) {
+		once.Do(func() { b.record(probe, o) })
+	}, nil
+}
+
+// record updates the breaker with the outcome of a request. Neutral outcomes only free the probe slot
+// of half-open breakers.
+func (b *circuitBreaker) record(probe bool, o outcome) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if probe {
+		b.halfOpenInFlight--
+	}
+
+	switch o {
+	case outcomeNeutral:
+		return
+	case outcomeSuccess:
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			b.setStateLocked(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.config.FailureThreshold) {
+		b.openedAt = time.Now()
+		b.setStateLocked(BreakerOpen)
+	}
+}
+
+// setStateLocked transitions the breaker to a new state and logs the change.
+func (b *circuitBreaker) setStateLocked(state BreakerState) {
+	if b.state == state {
+		return
+	}
+
+	slog.Warn("circuitBreaker: state changed", "endpoint", b.endpoint, "from", b.state, "to", state, "consecutive_failures", b.failures)
+	b.state = state
+}
+
+// status returns a snapshot of the breaker.
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	s := BreakerStatus{
+		Endpoint:            b.endpoint,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt.UTC()
+		s.OpenedAt = &openedAt
+	}
+
+	return s
+}
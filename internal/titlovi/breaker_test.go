@@ -0,0 +1,59 @@
+package titlovi
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOutcomes(t *testing.T) {
+	b := newCircuitBreaker(EndpointSearch, BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Millisecond, HalfOpenMaxRequests: 1})
+
+	fail := func() {
+		t.Helper()
+		done, err := b.allow()
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		done(outcomeFailure)
+	}
+
+	fail()
+	done, _ := b.allow()
+	done(outcomeNeutral)
+	if got := b.status().ConsecutiveFailures; got != 1 {
+		t.Fatalf("neutral outcome changed failures to %d, want 1", got)
+	}
+
+	fail()
+	if got := b.status().State; got != BreakerOpen {
+		t.Fatalf("state after %d failures = %s, want open", 2, got)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow while open: got %v, want ErrCircuitOpen", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	done, err := b.allow()
+	if err != nil {
+		t.Fatalf("allow after open timeout: %v", err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe while half-open: got %v, want ErrCircuitOpen", err)
+	}
+
+	// A neutral probe frees its slot but neither closes nor reopens the breaker.
+	done(outcomeNeutral)
+	if got := b.status().State; got != BreakerHalfOpen {
+		t.Fatalf("state after neutral probe = %s, want half-open", got)
+	}
+
+	done, err = b.allow()
+	if err != nil {
+		t.Fatalf("allow after neutral probe: %v", err)
+	}
+	done(outcomeSuccess)
+	if s := b.status(); s.State != BreakerClosed || s.ConsecutiveFailures != 0 {
+		t.Fatalf("status after successful probe = %+v, want closed without failures", s)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"go-titlovi/internal/config"
+	"go-titlovi/internal/metrics"
+	"go-titlovi/internal/tracing"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -23,57 +26,326 @@ type Client struct {
 	clientLoginData map[string]*LoginData
 	mtx             sync.RWMutex
 	http            http.Client
-	retryAttempts   uint
-	retryDelay      time.Duration
+	apiURL          string
+	downloadURL     string
+	retryPolicy     RetryPolicy
+	policyMtx       sync.RWMutex // Guards retryPolicy, which can change on reloads.
+	userAgent       string
+	overallTimeout  time.Duration
+	// Limiters protecting each Titlovi.com endpoint from too many concurrent requests.
+	limiters map[Endpoint]*upstreamLimiter
+	// Circuit breakers failing requests fast for each Titlovi.com endpoint that is down.
+	breakers map[Endpoint]*circuitBreaker
 }
 
-func NewClient(retryAttempts uint, retryDelay time.Duration) *Client {
+// RetryPolicy configures how requests toward Titlovi.com that failed with a transient error are retried.
+type RetryPolicy struct {
+	Attempts  uint          // How many times to try a request in total.
+	Delay     time.Duration // The delay before the first retry, doubled for every following one.
+	MaxDelay  time.Duration // The maximum delay in-between retries. Requests asked to wait longer by Titlovi.com are not retried.
+	MaxJitter time.Duration // The maximum random delay added to every retry.
+}
+
+// errTokenExpired is returned when a search has to be retried with a new token.
+var errTokenExpired = errors.New("retry with new token")
+
+// NewClient creates a Client from the configuration, with the provided options taking precedence over it.
+func NewClient(cfg config.TitloviConfig, opts ...Option) (*Client, error) {
+	o, err := defaultClientOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	return &Client{
 		clientLoginData: make(map[string]*LoginData, 0),
-		retryAttempts:   retryAttempts,
-		retryDelay:      retryDelay,
+		http: http.Client{
+			Transport: o.buildTransport(),
+			Timeout:   o.requestTimeout,
+		},
+		apiURL:         o.apiURL,
+		downloadURL:    o.downloadURL,
+		retryPolicy:    o.retryPolicy,
+		userAgent:      o.userAgent,
+		overallTimeout: o.overallTimeout,
+		limiters: map[Endpoint]*upstreamLimiter{
+			EndpointLogin:    newUpstreamLimiter(o.limits.Login),
+			EndpointSearch:   newUpstreamLimiter(o.limits.Search),
+			EndpointDownload: newUpstreamLimiter(o.limits.Download),
+		},
+		breakers: map[Endpoint]*circuitBreaker{
+			EndpointLogin:    newCircuitBreaker(EndpointLogin, o.breaker),
+			EndpointSearch:   newCircuitBreaker(EndpointSearch, o.breaker),
+			EndpointDownload: newCircuitBreaker(EndpointDownload, o.breaker),
+		},
+	}, nil
+}
+
+// Reconfigure applies the settings of the configuration that can change while the client is in use,
+// which is currently only the retry policy.
+func (c *Client) Reconfigure(cfg config.TitloviConfig) {
+	c.policyMtx.Lock()
+	defer c.policyMtx.Unlock()
+
+	c.retryPolicy = retryPolicyFromConfig(cfg.Retry)
+}
+
+// ActiveTokens returns how many login tokens are held that have not expired yet.
+func (c *Client) ActiveTokens() int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	now := time.Now()
+	active := 0
+	for _, d := range c.clientLoginData {
+		// Tokens with an expiration date we cannot parse are used until Titlovi.com rejects them.
+		if expires, err := time.Parse(time.RFC3339, d.ExpirationDate); err != nil || expires.After(now) {
+			active++
+		}
 	}
+	return active
 }
 
-// Login attempts a login to the Titlovi.com API and internally stores the retrieved token if successful.
-func (c *Client) Login(ctx context.Context, username, password string) (*LoginData, error) {
-	params := url.Values{}
-	params.Add("username", username)
-	params.Add("password", password)
-	url := fmt.Sprintf("%s/gettoken?%s", config.TitloviApi, params.Encode())
+// observeError counts an operation toward the endpoint that failed, by the class of the error.
+func observeError(endpoint Endpoint, err error) {
+	if err == nil {
+		return
+	}
+
+	class := KindOf(err).String()
+	if errors.Is(err, context.Canceled) {
+		class = "canceled"
+	}
+	metrics.UpstreamErrors.Inc(string(endpoint), class)
+}
+
+// withOverallTimeout bounds the context of an operation by the overall timeout, if one is set.
+func (c *Client) withOverallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.overallTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.overallTimeout)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+// BreakerStatus returns the state of the circuit breaker for every endpoint.
+func (c *Client) BreakerStatus() []BreakerStatus {
+	return []BreakerStatus{
+		c.breakers[EndpointLogin].status(),
+		c.breakers[EndpointSearch].status(),
+		c.breakers[EndpointDownload].status(),
+	}
+}
+
+// Probe checks that the Titlovi.com API can be reached, without logging in or counting toward limits
+// and circuit breakers. Any response that is not a server error counts as reachable.
+func (c *Client) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.apiURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("create login request: %w", err)
+		return fmt.Errorf("create probe request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.userAgent)
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("post login: %w", err)
+		return networkError(ctx, EndpointSearch, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode > 299 {
-		return nil, fmt.Errorf("login: %s", resp.Status)
+	if resp.StatusCode >= 500 {
+		return responseError(EndpointSearch, resp)
+	}
+	return nil
+}
+
+// do sends a request to an endpoint once its circuit breaker and limiter let it through.
+//
+// The limiter slot is held until the response body is closed.
+func (c *Client) do(endpoint Endpoint, req *http.Request) (_ *http.Response, err error) {
+	// The URL is not recorded in full, since the query holds credentials and tokens.
+	ctx, span := tracing.Start(req.Context(), req.Method+" "+string(endpoint), tracing.KindClient,
+		"http.request.method", req.Method,
+		"server.address", req.URL.Hostname(),
+		"url.path", req.URL.Path,
+		"titlovi.endpoint", string(endpoint),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", c.userAgent)
+	tracing.Inject(ctx, req.Header)
+
+	done, err := c.breakers[endpoint].allow()
+	if err != nil {
+		return nil, newError(KindUnavailable, endpoint, err)
+	}
+
+	queued := time.Now()
+	release, err := c.limiters[endpoint].acquire(req.Context())
+	if err != nil {
+		// Waiting for a slot says nothing about the health of the endpoint.
+		done(outcomeNeutral)
+		if errors.Is(err, ErrQueueTimeout) || errors.Is(err, ErrQueueFull) {
+			return nil, newError(KindUnavailable, endpoint, err)
+		}
+		return nil, fmt.Errorf("%s: %w", endpoint, err)
+	}
+	span.SetAttributes("titlovi.queue_duration_ms", time.Since(queued).Milliseconds())
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		slog.DebugContext(req.Context(), "do: request to Titlovi.com failed", "endpoint", endpoint, "duration", time.Since(start), "error", err)
+		metrics.UpstreamRequestDuration.Observe(metrics.Since(start), string(endpoint), "error")
+
+		// Requests cancelled by the caller are not the fault of the endpoint, but ones that ran out of time are.
+		if errors.Is(req.Context().Err(), context.Canceled) {
+			done(outcomeNeutral)
+		} else {
+			done(outcomeFailure)
+		}
+		release()
+		return nil, networkError(req.Context(), endpoint, err)
+	}
+	slog.DebugContext(req.Context(), "do: request to Titlovi.com done", "endpoint", endpoint, "status", resp.StatusCode, "duration", time.Since(start))
+	metrics.UpstreamRequestDuration.Observe(metrics.Since(start), string(endpoint), strconv.Itoa(resp.StatusCode))
+	span.SetAttributes("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetStatus(tracing.StatusError, resp.Status)
+	}
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		done(outcomeSuccess)
+	} else {
+		done(outcomeFailure)
+	}
+
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// retryOptions returns the options for retry.Do according to the retry policy.
+func (c *Client) retryOptions(ctx context.Context) []retry.Option {
+	c.policyMtx.RLock()
+	policy := c.retryPolicy
+	c.policyMtx.RUnlock()
+
+	return []retry.Option{
+		retry.Context(ctx),
+		retry.Attempts(policy.Attempts),
+		retry.Delay(policy.Delay),
+		retry.MaxDelay(policy.MaxDelay),
+		retry.MaxJitter(policy.MaxJitter),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			slog.WarnContext(ctx, "retryOptions: attempt toward Titlovi.com failed", "attempt", n+1, "attempts", policy.Attempts, "error", err)
+			tracing.SpanFromContext(ctx).AddEvent("retry", "attempt", int(n+1), "error", err.Error())
+		}),
+		retry.RetryIf(func(err error) bool {
+			// Retrying once out of time would only replace the error with the one of the context.
+			if ctx.Err() != nil {
+				return false
+			}
+			// There is no point in retrying if Titlovi.com asked us to wait for longer than we are willing to.
+			if after := RetryAfterOf(err); policy.MaxDelay > 0 && after > policy.MaxDelay {
+				return false
+			}
+			return KindOf(err).Retryable()
+		}),
+		retry.DelayType(func(n uint, err error, config *retry.Config) time.Duration {
+			if after := RetryAfterOf(err); after > 0 {
+				return after
+			}
+			if policy.MaxJitter <= 0 {
+				return retry.BackOffDelay(n, err, config)
+			}
+			return retry.CombineDelay(retry.BackOffDelay, retry.RandomDelay)(n, err, config)
+		}),
 	}
+}
+
+// Login attempts a login to the Titlovi.com API and internally stores the retrieved token if successful.
+func (c *Client) Login(ctx context.Context, username, password string) (_ *LoginData, err error) {
+	ctx, span := tracing.Start(ctx, "titlovi.Login", tracing.KindInternal)
+	defer func() {
+		observeError(EndpointLogin, err)
+		span.RecordError(err)
+		span.End()
+	}()
+
+	ctx, cancel := c.withOverallTimeout(ctx)
+	defer cancel()
+
+	params := url.Values{}
+	params.Add("username", username)
+	params.Add("password", password)
+	url := fmt.Sprintf("%s/gettoken?%s", c.apiURL, params.Encode())
+	var body []byte
+
+	err = retry.Do(func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return fmt.Errorf("create login request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := c.do(EndpointLogin, req)
+		if err != nil {
+			return fmt.Errorf("post login: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode > 299 {
+			e := responseError(EndpointLogin, resp)
+			if e.Kind == KindBadRequest {
+				// Titlovi.com responds to wrong credentials with a bad request as well.
+				e.Kind = KindAuth
+			}
+			return e
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return newError(KindNetwork, EndpointLogin, fmt.Errorf("response read: %w", err))
+		}
 
-	body, err := io.ReadAll(resp.Body)
+		return nil
+	}, c.retryOptions(ctx)...)
 	if err != nil {
-		return nil, fmt.Errorf("response read: %w", err)
+		return nil, err
 	}
 
 	loginData := &LoginData{}
 	err = json.Unmarshal(body, loginData)
 	if err != nil {
-		return nil, fmt.Errorf("response unmarshal: %w", err)
+		return nil, newError(KindDecode, EndpointLogin, fmt.Errorf("response unmarshal: %w", err))
 	}
 
 	return loginData, nil
 }
 
 // Search performs a search on the Titlovi.com API and returns a slice of titlovi.SubtitleData if successful.
-func (c *Client) Search(ctx context.Context, imdbId, season, episode string, languages []string, username, password string) ([]SubtitleData, error) {
+//
+// The query is usually an IMDb ID, but Titlovi.com matches titles as well.
+func (c *Client) Search(ctx context.Context, query, season, episode string, languages []string, username, password string) (_ []SubtitleData, err error) {
+	ctx, span := tracing.Start(ctx, "titlovi.Search", tracing.KindInternal,
+		"titlovi.query", query,
+		"titlovi.season", season,
+		"titlovi.episode", episode,
+		"titlovi.languages", strings.Join(languages, "|"),
+	)
+	defer func() {
+		observeError(EndpointSearch, err)
+		span.RecordError(err)
+		span.End()
+	}()
+
+	ctx, cancel := c.withOverallTimeout(ctx)
+	defer cancel()
+
 	d, err := c.getLoginData(ctx, username, password, false)
 	if err != nil {
 		return nil, fmt.Errorf("get login data: %w", err)
@@ -82,7 +354,7 @@ func (c *Client) Search(ctx context.Context, imdbId, season, episode string, lan
 	params := url.Values{}
 	params.Add("token", d.Token)
 	params.Add("userid", strconv.Itoa(int(d.UserId)))
-	params.Add("query", imdbId)
+	params.Add("query", query)
 	params.Add("lang", strings.Join(languages, "|"))
 
 	if season != "" {
@@ -92,44 +364,53 @@ func (c *Client) Search(ctx context.Context, imdbId, season, episode string, lan
 		params.Add("episode", episode)
 	}
 
-	url := fmt.Sprintf("%s/search?%s", config.TitloviApi, params.Encode())
+	url := fmt.Sprintf("%s/search?%s", c.apiURL, params.Encode())
 	var body []byte
 
-	err = retry.Do(func() error {
+	search := func() error {
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
 			return fmt.Errorf("create search request: %w", err)
 		}
-		resp, err := c.http.Do(req)
+		resp, err := c.do(EndpointSearch, req)
 		if err != nil {
 			return fmt.Errorf("get search: %w", err)
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode == 401 {
-			// Retry search with new token
-			d, loginErr := c.getLoginData(ctx, username, password, true)
-			if loginErr != nil {
-				return fmt.Errorf("get login data retry: %w", err)
-			}
-
-			params.Set("token", d.Token)
-			params.Set("userid", strconv.Itoa(int(d.UserId)))
-			url = fmt.Sprintf("%s/search?%s", config.TitloviApi, params.Encode())
-			return errors.New("retry with new token")
+		if resp.StatusCode == http.StatusUnauthorized {
+			return errTokenExpired
 		}
 
 		if resp.StatusCode > 299 {
-			return fmt.Errorf("get: %s", resp.Status)
-		} else {
-			body, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return fmt.Errorf("response read: %w", err)
-			}
+			return responseError(EndpointSearch, resp)
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return newError(KindNetwork, EndpointSearch, fmt.Errorf("response read: %w", err))
 		}
 
 		return nil
-	}, retry.Attempts(c.retryAttempts), retry.Delay(c.retryDelay))
+	}
+
+	err = retry.Do(search, c.retryOptions(ctx)...)
+	if errors.Is(err, errTokenExpired) {
+		// The token expired, so the search is made once more with a new one, regardless of the retry policy.
+		d, loginErr := c.getLoginData(ctx, username, password, true)
+		if loginErr != nil {
+			return nil, fmt.Errorf("get login data retry: %w", loginErr)
+		}
+
+		params.Set("token", d.Token)
+		params.Set("userid", strconv.Itoa(int(d.UserId)))
+		url = fmt.Sprintf("%s/search?%s", c.apiURL, params.Encode())
+		err = retry.Do(search, c.retryOptions(ctx)...)
+	}
+	if errors.Is(err, errTokenExpired) {
+		// Titlovi.com rejected a freshly issued token.
+		return nil, newError(KindAuth, EndpointSearch, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -137,43 +418,62 @@ func (c *Client) Search(ctx context.Context, imdbId, season, episode string, lan
 	subtitleResponse := SubtitleDataResponse{}
 	err = json.Unmarshal(body, &subtitleResponse)
 	if err != nil {
-		return nil, fmt.Errorf("response unmarshal: %w", err)
+		return nil, newError(KindDecode, EndpointSearch, fmt.Errorf("response unmarshal: %w", err))
 	}
 
+	span.SetAttributes("titlovi.results", len(subtitleResponse.Subtitles))
 	return subtitleResponse.Subtitles, nil
 }
 
 // Download downloads a subtitle from Titlovi.com based on the provided type and ID and returns it as a blob.
-func (c *Client) Download(ctx context.Context, mediaType string, mediaId string) ([]byte, error) {
-	url := fmt.Sprintf("%s/?type=%s&mediaid=%s", config.TitloviDownload, mediaType, mediaId)
+func (c *Client) Download(ctx context.Context, mediaType string, mediaId string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "titlovi.Download", tracing.KindInternal,
+		"titlovi.media_type", mediaType,
+		"titlovi.media_id", mediaId,
+	)
+	defer func() {
+		observeError(EndpointDownload, err)
+		span.RecordError(err)
+		span.End()
+	}()
+
+	ctx, cancel := c.withOverallTimeout(ctx)
+	defer cancel()
+
+	params := url.Values{}
+	params.Add("type", mediaType)
+	params.Add("mediaid", mediaId)
+
+	url := fmt.Sprintf("%s/?%s", c.downloadURL, params.Encode())
 	var body []byte
 
-	err := retry.Do(func() error {
+	err = retry.Do(func() error {
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
 			return fmt.Errorf("create download request: %w", err)
 		}
-		resp, err := c.http.Do(req)
+		resp, err := c.do(EndpointDownload, req)
 		if err != nil {
 			return fmt.Errorf("get subtitle: %w", err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode > 299 {
-			return fmt.Errorf("get: %w", err)
-		} else {
-			body, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return fmt.Errorf("response read: %w", err)
-			}
+			return responseError(EndpointDownload, resp)
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return newError(KindNetwork, EndpointDownload, fmt.Errorf("response read: %w", err))
 		}
 
 		return nil
-	}, retry.Attempts(c.retryAttempts), retry.Delay(c.retryDelay))
+	}, c.retryOptions(ctx)...)
 	if err != nil {
 		return nil, err
 	}
 
+	span.SetAttributes("titlovi.size", len(body))
 	return body, nil
 }
 
@@ -186,6 +486,7 @@ func (c *Client) getLoginData(ctx context.Context, username, password string, fo
 
 	// If we don't have it, get it
 	if !ok || forceLogin {
+		slog.InfoContext(ctx, "getLoginData: logging in to Titlovi.com", "forced", forceLogin)
 		d, err = c.Login(ctx, username, password)
 		if err != nil {
 			return nil, fmt.Errorf("login: %w", err)
@@ -0,0 +1,242 @@
+package titlovi_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go-titlovi/internal/config"
+	"go-titlovi/internal/titlovi"
+	"go-titlovi/internal/titlovi/titlovitest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newClient starts a fake with a user and a subtitle for tt0111161, and creates a client pointed at it.
+func newClient(t *testing.T, opts ...titlovi.Option) (*titlovi.Client, *titlovitest.Server) {
+	t.Helper()
+
+	fake := titlovitest.NewServer()
+	t.Cleanup(fake.Close)
+	fake.AddUser("user", "secret")
+	fake.AddSubtitle(titlovitest.Subtitle{
+		Query: "tt0111161",
+		Data:  titlovi.SubtitleData{Id: 1, Type: 1, Title: "The Shawshank Redemption", Lang: "Bosanski"},
+	})
+
+	client, err := titlovi.NewClient(config.Default().Titlovi, append(fake.ClientOptions(), opts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client, fake
+}
+
+func TestLogin(t *testing.T) {
+	client, _ := newClient(t)
+
+	d, err := client.Login(context.Background(), "user", "secret")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if d.Token == "" || d.Username != "user" {
+		t.Errorf("Login = %+v, want a token for user", d)
+	}
+
+	_, err = client.Login(context.Background(), "user", "wrong")
+	if kind := titlovi.KindOf(err); kind != titlovi.KindAuth {
+		t.Errorf("Login with a wrong password: kind %s, want auth (error %v)", kind, err)
+	}
+}
+
+func TestSearchRenewsExpiredToken(t *testing.T) {
+	// Renewing the token does not count as a retry.
+	client, fake := newClient(t, titlovi.WithRetryPolicy(titlovi.RetryPolicy{Attempts: 1}))
+	ctx := context.Background()
+
+	for i := range 2 {
+		results, err := client.Search(ctx, "tt0111161", "", "", []string{"Bosanski"}, "user", "secret")
+		if err != nil {
+			t.Fatalf("Search %d: %v", i+1, err)
+		}
+		if len(results) != 1 || results[0].Id != 1 {
+			t.Fatalf("Search %d = %+v, want the fixture", i+1, results)
+		}
+		if got := fake.Requests(titlovi.EndpointLogin); got != 1 {
+			t.Fatalf("logins after search %d = %d, want 1, since the token is reused", i+1, got)
+		}
+	}
+
+	fake.ExpireTokens()
+	if _, err := client.Search(ctx, "tt0111161", "", "", []string{"Bosanski"}, "user", "secret"); err != nil {
+		t.Fatalf("Search with an expired token: %v", err)
+	}
+	if got := fake.Requests(titlovi.EndpointLogin); got != 2 {
+		t.Errorf("logins after the token expired = %d, want 2", got)
+	}
+}
+
+func TestSearchFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		faults   []titlovitest.Fault
+		attempts uint
+		want     titlovi.ErrorKind // KindUnknown for success.
+		searches int
+	}{
+		{name: "server error", faults: []titlovitest.Fault{{Status: http.StatusInternalServerError}}, attempts: 1, want: titlovi.KindUpstream, searches: 1},
+		{name: "rate limited", faults: []titlovitest.Fault{{Status: http.StatusTooManyRequests, RetryAfter: "1"}}, attempts: 1, want: titlovi.KindRateLimited, searches: 1},
+		{name: "bad request", faults: []titlovitest.Fault{{Status: http.StatusBadRequest}}, attempts: 3, want: titlovi.KindBadRequest, searches: 1},
+		{name: "server error retried", faults: []titlovitest.Fault{{Status: http.StatusInternalServerError}}, attempts: 2, searches: 2},
+		{name: "unauthorized renews token", faults: []titlovitest.Fault{{Status: http.StatusUnauthorized}}, attempts: 1, searches: 2},
+		{name: "unauthorized after renewal", faults: []titlovitest.Fault{{Status: http.StatusUnauthorized}, {Status: http.StatusUnauthorized}}, attempts: 3, want: titlovi.KindAuth, searches: 2},
+		{name: "rate limited for too long", faults: []titlovitest.Fault{{Status: http.StatusTooManyRequests, RetryAfter: "3600"}}, attempts: 3, want: titlovi.KindRateLimited, searches: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newClient(t, titlovi.WithRetryPolicy(titlovi.RetryPolicy{Attempts: tt.attempts, Delay: time.Millisecond, MaxDelay: time.Second}))
+			fake.InjectFaults(titlovi.EndpointSearch, tt.faults...)
+
+			_, err := client.Search(context.Background(), "tt0111161", "", "", []string{"Bosanski"}, "user", "secret")
+			if tt.want == titlovi.KindUnknown && err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if kind := titlovi.KindOf(err); kind != tt.want {
+				t.Fatalf("Search: kind %s, want %s (error %v)", kind, tt.want, err)
+			}
+			if got := fake.Requests(titlovi.EndpointSearch); got != tt.searches {
+				t.Errorf("searches = %d, want %d", got, tt.searches)
+			}
+		})
+	}
+}
+
+func TestSearchRetryAfter(t *testing.T) {
+	client, fake := newClient(t)
+	fake.InjectFaults(titlovi.EndpointSearch, titlovitest.Fault{Status: http.StatusTooManyRequests, RetryAfter: "120"})
+
+	_, err := client.Search(context.Background(), "tt0111161", "", "", nil, "user", "secret")
+	if after := titlovi.RetryAfterOf(err); after != 2*time.Minute {
+		t.Errorf("RetryAfterOf = %s, want 2m (error %v)", after, err)
+	}
+}
+
+func TestQueueTimeoutIsUnavailable(t *testing.T) {
+	client, _ := newClient(t, titlovi.WithUpstreamLimits(titlovi.UpstreamLimits{
+		Search: titlovi.LimiterConfig{Rate: 0.1, Burst: 1, QueueTimeout: 10 * time.Millisecond},
+	}))
+	ctx := context.Background()
+
+	if _, err := client.Search(ctx, "tt0111161", "", "", nil, "user", "secret"); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	// The next search token comes in ten seconds, after the queue timeout.
+	_, err := client.Search(ctx, "tt0111161", "", "", nil, "user", "secret")
+	if kind := titlovi.KindOf(err); kind != titlovi.KindUnavailable {
+		t.Errorf("Search without a free slot in time: kind %s, want unavailable (error %v)", kind, err)
+	}
+}
+
+func TestTimeoutsOpenBreaker(t *testing.T) {
+	client, fake := newClient(t,
+		titlovi.WithOverallTimeout(20*time.Millisecond),
+		titlovi.WithBreaker(titlovi.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}),
+	)
+	if _, err := client.Login(context.Background(), "user", "secret"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	fake.SetLatency(time.Second)
+
+	_, err := client.Download(context.Background(), "1", "1")
+	if kind := titlovi.KindOf(err); kind != titlovi.KindNetwork {
+		t.Fatalf("Download from a slow endpoint: kind %s, want network (error %v)", kind, err)
+	}
+	_, err = client.Download(context.Background(), "1", "1")
+	if kind := titlovi.KindOf(err); kind != titlovi.KindUnavailable {
+		t.Errorf("Download after a timeout: kind %s, want unavailable, as the breaker should be open (error %v)", kind, err)
+	}
+}
+
+func TestCancellationKeepsBreakerClosed(t *testing.T) {
+	client, fake := newClient(t, titlovi.WithBreaker(titlovi.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+	fake.SetLatency(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := client.Download(ctx, "1", "1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download cancelled by the caller: got %v, want context.Canceled", err)
+	}
+
+	for _, s := range client.BreakerStatus() {
+		if s.State != titlovi.BreakerClosed || s.ConsecutiveFailures != 0 {
+			t.Errorf("breaker after cancellation = %+v, want closed without failures", s)
+		}
+	}
+}
+
+func TestDownloadEncodings(t *testing.T) {
+	const text = "Čaša žute šljive, Đurđa i ćevapi."
+
+	windows1250, err := titlovitest.Encode(titlovitest.SRT(text), "windows-1250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utf8 := []byte(titlovitest.SRT(text))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"windows-1250", windows1250},
+		{"utf-8", utf8},
+		{"utf-8 with byte order mark", append([]byte("\ufeff"), utf8...)},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newClient(t)
+			mediaId := string(rune('1' + i))
+			archive, err := titlovitest.ZIP(
+				titlovitest.File{Name: "readme.txt", Data: []byte("not a subtitle")},
+				titlovitest.File{Name: "movie.srt", Data: tt.data},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake.SetPayload("1", mediaId, archive)
+
+			data, err := client.Download(context.Background(), "1", mediaId)
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			subtitle, err := titlovi.ExtractSubtitleFromZIP(data)
+			if err != nil {
+				t.Fatalf("ExtractSubtitleFromZIP: %v", err)
+			}
+			converted, err := titlovi.ConvertSubtitleToUTF8(subtitle)
+			if err != nil {
+				t.Fatalf("ConvertSubtitleToUTF8: %v", err)
+			}
+			if want := titlovitest.SRT(text); !bytes.Equal(converted, []byte(want)) {
+				t.Errorf("converted subtitle = %q, want %q", converted, want)
+			}
+		})
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	client, _ := newClient(t)
+
+	_, err := client.Download(context.Background(), "1", "404")
+	if kind := titlovi.KindOf(err); kind != titlovi.KindNotFound {
+		t.Errorf("Download of a missing subtitle: kind %s, want not-found (error %v)", kind, err)
+	}
+}
+
+func TestExtractSubtitleFromRAR(t *testing.T) {
+	archive := titlovitest.RAR(titlovitest.File{Name: "movie.srt", Data: []byte(titlovitest.SRT("Zdravo"))})
+
+	if _, err := titlovi.ExtractSubtitleFromZIP(archive); err == nil {
+		t.Error("ExtractSubtitleFromZIP of a RAR archive succeeded, want an error, as only ZIP archives are supported")
+	}
+}
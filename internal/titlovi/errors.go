@@ -0,0 +1,152 @@
+package titlovi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind classifies errors returned by the Client.
+type ErrorKind int
+
+const (
+	KindUnknown     ErrorKind = iota
+	KindAuth                  // The credentials were rejected by Titlovi.com.
+	KindNotFound              // The requested resource does not exist on Titlovi.com.
+	KindBadRequest            // Titlovi.com rejected the request for any other client-side reason.
+	KindRateLimited           // Titlovi.com is rate limiting us.
+	KindUpstream              // Titlovi.com responded with a server error.
+	KindNetwork               // Titlovi.com could not be reached or did not respond in time.
+	KindDecode                // The response from Titlovi.com could not be understood.
+	KindUnavailable           // The request was not sent, as the endpoint is known to be down or overloaded.
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindNotFound:
+		return "not-found"
+	case KindBadRequest:
+		return "bad-request"
+	case KindRateLimited:
+		return "rate-limited"
+	case KindUpstream:
+		return "upstream"
+	case KindNetwork:
+		return "network"
+	case KindDecode:
+		return "decode"
+	case KindUnavailable:
+		return "unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// Retryable reports whether errors of this kind are transient and may succeed if retried.
+func (k ErrorKind) Retryable() bool {
+	return k == KindRateLimited || k == KindUpstream || k == KindNetwork
+}
+
+// Error is returned by the Client for any request toward Titlovi.com that failed.
+type Error struct {
+	Kind       ErrorKind
+	Endpoint   Endpoint
+	StatusCode int           // The status Titlovi.com responded with, if it responded.
+	RetryAfter time.Duration // How long Titlovi.com asked us to wait before retrying, if it did.
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: %s error (status %d): %s", e.Endpoint, e.Kind, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s: %s error: %s", e.Endpoint, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of an error returned by the Client, or KindUnknown if it is not an *Error.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindUnknown
+}
+
+// RetryAfterOf returns how long Titlovi.com asked us to wait before retrying, if the error carries it.
+func RetryAfterOf(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// newError creates an *Error of the provided kind.
+func newError(kind ErrorKind, endpoint Endpoint, err error) *Error {
+	return &Error{Kind: kind, Endpoint: endpoint, Err: err}
+}
+
+// networkError creates an *Error for a request that failed to get a response.
+//
+// Errors caused by the caller cancelling the request are kept unclassified, so that they are not retried.
+// Requests that ran out of time are network errors, as Titlovi.com did not respond in time.
+func networkError(ctx context.Context, endpoint Endpoint, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+	return newError(KindNetwork, endpoint, err)
+}
+
+// responseError creates an *Error for a response with a non-successful status.
+func responseError(endpoint Endpoint, resp *http.Response) *Error {
+	e := &Error{
+		Endpoint:   endpoint,
+		StatusCode: resp.StatusCode,
+		Err:        errors.New(resp.Status),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Kind = KindAuth
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		e.Kind = KindNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = KindRateLimited
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		e.Kind = KindUpstream
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= 500:
+		e.Kind = KindUpstream
+	default:
+		e.Kind = KindBadRequest
+	}
+
+	return e
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,218 @@
+package titlovi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Endpoint identifies a Titlovi.com endpoint that requests are limited for separately.
+type Endpoint string
+
+const (
+	EndpointLogin    Endpoint = "login"
+	EndpointSearch   Endpoint = "search"
+	EndpointDownload Endpoint = "download"
+)
+
+// Priority determines the order in which queued requests toward Titlovi.com are let through.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota // Requests a user is actively waiting for.
+	PriorityPrefetch                    // Requests made ahead of time, which can wait for interactive ones.
+
+	numPriorities int = 2
+)
+
+var (
+	ErrQueueTimeout = errors.New("timed out waiting for a free upstream slot")
+	ErrQueueFull    = errors.New("upstream request queue is full")
+)
+
+type priorityContextKey struct{}
+
+// WithPriority returns a context that marks requests made with it to Titlovi.com with the provided priority.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, p)
+}
+
+// priorityFromContext returns the priority set with WithPriority, defaulting to PriorityInteractive.
+func priorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityContextKey{}).(Priority); ok && p >= 0 && int(p) < numPriorities {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// LimiterConfig configures how many requests can be made toward a single Titlovi.com endpoint.
+//
+// Any limit left at zero is not enforced.
+type LimiterConfig struct {
+	MaxInFlight  int           // How many requests can be in flight at once.
+	Rate         float64       // How many requests to start within a second.
+	Burst        int           // How many burst requests do we allow to start.
+	MaxQueue     int           // How many requests can wait for a slot before new ones are rejected.
+	QueueTimeout time.Duration // How long a request can wait for a slot before giving up.
+}
+
+// UpstreamLimits holds the LimiterConfig for every Titlovi.com endpoint.
+type UpstreamLimits struct {
+	Login    LimiterConfig
+	Search   LimiterConfig
+	Download LimiterConfig
+}
+
+// waiter is a request queued for a slot.
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// upstreamLimiter limits both the concurrency and the rate of requests toward an endpoint.
+//
+// Requests that cannot be let through immediately are queued, with interactive requests always being
+// let through before prefetch ones.
+type upstreamLimiter struct {
+	mtx      sync.Mutex
+	inFlight int
+	queued   int
+	queues   [numPriorities][]*waiter
+	rate     *rate.Limiter
+	config   LimiterConfig
+}
+
+// newUpstreamLimiter creates an upstreamLimiter from the provided configuration.
+func newUpstreamLimiter(config LimiterConfig) *upstreamLimiter {
+	limit := rate.Limit(config.Rate)
+	if config.Rate <= 0 {
+		limit = rate.Inf
+	}
+
+	return &upstreamLimiter{
+		rate:   rate.NewLimiter(limit, config.Burst),
+		config: config,
+	}
+}
+
+// acquire waits until a request can be made and returns a function that must be called once it is done.
+func (l *upstreamLimiter) acquire(ctx context.Context) (func(), error) {
+	queueCtx := ctx
+	if l.config.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		queueCtx, cancel = context.WithTimeout(ctx, l.config.QueueTimeout)
+		defer cancel()
+	}
+
+	if err := l.acquireSlot(queueCtx, priorityFromContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	// Wait fails right away if the next token comes after the deadline, before the context is done, so any
+	// failure is a queue timeout unless the caller gave up.
+	if err := l.rate.Wait(queueCtx); err != nil {
+		l.release()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrQueueTimeout, err)
+	}
+
+	var once sync.Once
+	return func() { once.Do(l.release) }, nil
+}
+
+// acquireSlot takes one of the in-flight slots, queueing if none are free.
+func (l *upstreamLimiter) acquireSlot(ctx context.Context, p Priority) error {
+	l.mtx.Lock()
+	if (l.config.MaxInFlight <= 0 || l.inFlight < l.config.MaxInFlight) && l.queued == 0 {
+		l.inFlight++
+		l.mtx.Unlock()
+		return nil
+	}
+
+	if l.config.MaxQueue > 0 && l.queued >= l.config.MaxQueue {
+		l.mtx.Unlock()
+		return ErrQueueFull
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	l.queues[p] = append(l.queues[p], w)
+	l.queued++
+	l.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+
+		// The slot may have been handed over right as we gave up, in which case it has to be passed on.
+		if w.granted {
+			l.releaseLocked()
+		} else {
+			l.removeLocked(p, w)
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrQueueTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees up a slot, handing it over to the next queued request if there is one.
+func (l *upstreamLimiter) release() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.releaseLocked()
+}
+
+// releaseLocked is release for callers already holding the lock.
+func (l *upstreamLimiter) releaseLocked() {
+	for p := range l.queues {
+		if len(l.queues[p]) == 0 {
+			continue
+		}
+
+		w := l.queues[p][0]
+		l.queues[p] = l.queues[p][1:]
+		l.queued--
+
+		w.granted = true
+		close(w.ready)
+		return
+	}
+
+	l.inFlight--
+}
+
+// removeLocked removes a waiter from its queue.
+func (l *upstreamLimiter) removeLocked(p Priority, w *waiter) {
+	for i, queued := range l.queues[p] {
+		if queued == w {
+			l.queues[p] = append(l.queues[p][:i], l.queues[p][i+1:]...)
+			l.queued--
+			return
+		}
+	}
+}
+
+// releaseOnClose wraps a response body and releases the limiter slot once the body is closed,
+// so that the slot is held for as long as the response is being read.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
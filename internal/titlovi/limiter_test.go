@@ -0,0 +1,84 @@
+package titlovi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueTimeoutShorterThanRateInterval(t *testing.T) {
+	l := newUpstreamLimiter(LimiterConfig{MaxInFlight: 1, Rate: 1, Burst: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	release()
+
+	// The next token comes in a second, well after the queue timeout.
+	start := time.Now()
+	if _, err := l.acquire(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("acquire before the next token: got %v, want ErrQueueTimeout", err)
+	}
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("acquire waited %s for a token it could not get in time", waited)
+	}
+	if l.inFlight != 0 {
+		t.Errorf("in-flight requests after timing out = %d, want 0", l.inFlight)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire cancelled by the caller: got %v, want context.Canceled", err)
+	}
+}
+
+func TestInteractiveBeforePrefetch(t *testing.T) {
+	l := newUpstreamLimiter(LimiterConfig{MaxInFlight: 1, Rate: 100, Burst: 3, MaxQueue: 2, QueueTimeout: time.Second})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	order := make(chan Priority, 2)
+	queue := func(p Priority) {
+		go func() {
+			release, err := l.acquire(WithPriority(context.Background(), p))
+			if err != nil {
+				t.Errorf("acquire with priority %d: %v", p, err)
+				return
+			}
+			order <- p
+			release()
+		}()
+	}
+
+	// The prefetch request queues first, but is let through last.
+	queue(PriorityPrefetch)
+	waitQueued(t, l, 1)
+	queue(PriorityInteractive)
+	waitQueued(t, l, 2)
+	release()
+
+	if first, second := <-order, <-order; first != PriorityInteractive || second != PriorityPrefetch {
+		t.Errorf("let through priorities %d then %d, want interactive first", first, second)
+	}
+}
+
+// waitQueued waits until n requests are queued for the limiter.
+func waitQueued(t *testing.T, l *upstreamLimiter, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mtx.Lock()
+		queued := l.queued
+		l.mtx.Unlock()
+		if queued == n {
+			return
+		}
+	}
+	t.Fatalf("requests never queued, want %d", n)
+}
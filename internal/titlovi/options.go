@@ -0,0 +1,218 @@
+package titlovi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"go-titlovi/internal/config"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures a Client created with NewClient.
+type Option func(*clientOptions)
+
+// PoolConfig configures the connection pool of the transport toward Titlovi.com.
+type PoolConfig struct {
+	MaxIdleConns        int           // How many idle connections to keep in total.
+	MaxIdleConnsPerHost int           // How many idle connections to keep per host.
+	MaxConnsPerHost     int           // How many connections to open per host at most. Zero means no limit.
+	IdleConnTimeout     time.Duration // How long an idle connection is kept before being closed.
+}
+
+// clientOptions holds everything that can be configured through an Option.
+type clientOptions struct {
+	apiURL         string
+	downloadURL    string
+	retryPolicy    RetryPolicy
+	limits         UpstreamLimits
+	breaker        BreakerConfig
+	requestTimeout time.Duration
+	overallTimeout time.Duration
+	pool           PoolConfig
+	http2          bool
+	proxyURL       *url.URL
+	userAgent      string
+	transport      http.RoundTripper
+}
+
+// defaultClientOptions returns the options a Client is created with unless overridden, derived from the configuration.
+func defaultClientOptions(cfg config.TitloviConfig) (clientOptions, error) {
+	var proxyURL *url.URL
+	if cfg.ProxyURL != "" {
+		var err error
+		proxyURL, err = url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return clientOptions{}, fmt.Errorf("parse proxy URL: %w", err)
+		}
+	}
+
+	limiterConfig := func(limit config.EndpointLimitConfig) LimiterConfig {
+		return LimiterConfig{
+			MaxInFlight:  limit.MaxInFlight,
+			Rate:         limit.Rate,
+			Burst:        limit.Burst,
+			MaxQueue:     limit.MaxQueue,
+			QueueTimeout: cfg.QueueTimeout,
+		}
+	}
+
+	return clientOptions{
+		apiURL:      strings.TrimSuffix(cfg.APIURL, "/"),
+		downloadURL: strings.TrimSuffix(cfg.DownloadURL, "/"),
+		retryPolicy: retryPolicyFromConfig(cfg.Retry),
+		limits: UpstreamLimits{
+			Login:    limiterConfig(cfg.Login),
+			Search:   limiterConfig(cfg.Search),
+			Download: limiterConfig(cfg.Download),
+		},
+		breaker: BreakerConfig{
+			FailureThreshold:    cfg.Breaker.FailureThreshold,
+			OpenTimeout:         cfg.Breaker.OpenTimeout,
+			HalfOpenMaxRequests: cfg.Breaker.HalfOpenMaxRequests,
+		},
+		requestTimeout: cfg.RequestTimeout,
+		overallTimeout: cfg.OverallTimeout,
+		pool: PoolConfig{
+			MaxIdleConns:        cfg.Pool.MaxIdleConns,
+			MaxIdleConnsPerHost: cfg.Pool.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     cfg.Pool.MaxConnsPerHost,
+			IdleConnTimeout:     cfg.Pool.IdleConnTimeout,
+		},
+		http2:     cfg.HTTP2,
+		proxyURL:  proxyURL,
+		userAgent: "go-titlovi",
+	}, nil
+}
+
+// retryPolicyFromConfig converts the retry settings of the configuration into a RetryPolicy.
+func retryPolicyFromConfig(cfg config.RetryConfig) RetryPolicy {
+	return RetryPolicy{
+		Attempts:  cfg.Attempts,
+		Delay:     cfg.Delay,
+		MaxDelay:  cfg.MaxDelay,
+		MaxJitter: cfg.Jitter,
+	}
+}
+
+// WithAPIURL sets the base URL of the Titlovi.com API used for logins and searches.
+func WithAPIURL(apiURL string) Option {
+	return func(o *clientOptions) {
+		o.apiURL = strings.TrimSuffix(apiURL, "/")
+	}
+}
+
+// WithDownloadURL sets the URL subtitles are downloaded from.
+func WithDownloadURL(downloadURL string) Option {
+	return func(o *clientOptions) {
+		o.downloadURL = strings.TrimSuffix(downloadURL, "/")
+	}
+}
+
+// WithRetryPolicy sets how requests that failed with a transient error are retried.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *clientOptions) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithUpstreamLimits sets how many requests can be made toward each Titlovi.com endpoint.
+func WithUpstreamLimits(limits UpstreamLimits) Option {
+	return func(o *clientOptions) {
+		o.limits = limits
+	}
+}
+
+// WithBreaker sets when the circuit breaker of each Titlovi.com endpoint opens and how it recovers.
+func WithBreaker(breaker BreakerConfig) Option {
+	return func(o *clientOptions) {
+		o.breaker = breaker
+	}
+}
+
+// WithRequestTimeout sets how long a single attempt of a request can take, including reading the response.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithOverallTimeout sets how long a whole operation can take, including waiting for a slot and all retries.
+func WithOverallTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.overallTimeout = timeout
+	}
+}
+
+// WithConnectionPool sets the connection pool sizing of the transport.
+func WithConnectionPool(pool PoolConfig) Option {
+	return func(o *clientOptions) {
+		o.pool = pool
+	}
+}
+
+// WithHTTP2 sets whether the transport attempts to use HTTP/2.
+func WithHTTP2(enabled bool) Option {
+	return func(o *clientOptions) {
+		o.http2 = enabled
+	}
+}
+
+// WithProxy routes all requests through the provided outbound proxy. A nil URL uses the proxy from the environment.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(o *clientOptions) {
+		o.proxyURL = proxyURL
+	}
+}
+
+// WithUserAgent sets the User-Agent sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithTransport replaces the transport used to send requests, e.g. with a fake in tests.
+//
+// The connection pool, HTTP/2 and proxy options have no effect on a transport set this way.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// buildTransport creates the transport according to the options, unless one was provided.
+func (o *clientOptions) buildTransport() http.RoundTripper {
+	if o.transport != nil {
+		return o.transport
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if o.proxyURL != nil {
+		proxy = http.ProxyURL(o.proxyURL)
+	}
+
+	t := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     o.http2,
+		MaxIdleConns:          o.pool.MaxIdleConns,
+		MaxIdleConnsPerHost:   o.pool.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       o.pool.MaxConnsPerHost,
+		IdleConnTimeout:       o.pool.IdleConnTimeout,
+	}
+
+	if !o.http2 {
+		// A non-nil, empty map is what disables HTTP/2 on a transport.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return t
+}
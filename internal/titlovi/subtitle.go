@@ -5,9 +5,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"go-titlovi/internal/logger"
 	"io"
+	"log/slog"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/text/encoding/ianaindex"
 	"golang.org/x/text/transform"
@@ -39,7 +40,7 @@ func ExtractSubtitleFromZIP(zipData []byte) ([]byte, error) {
 			}
 			err = zipFile.Close()
 			if err != nil {
-				logger.LogError.Printf("ExtractSubtitleFromZIP: failed to close file from ZIP")
+				slog.Error("ExtractSubtitleFromZIP: failed to close file from ZIP", "error", err)
 			}
 
 			return buffer, nil
@@ -51,18 +52,25 @@ func ExtractSubtitleFromZIP(zipData []byte) ([]byte, error) {
 
 // ConvertSubtitleToUTF8 takes subtitle data, determines the charset and converts it to UTF-8.
 //
+// Subtitles that are valid UTF-8 already are returned without a byte order mark, and any other is taken to be
+// windows-1250, which most subtitles on Titlovi.com are encoded in.
+//
 // Returns the converted subtitle data or an error if conversion fails.
 func ConvertSubtitleToUTF8(subtitleData []byte) ([]byte, error) {
+	if utf8.Valid(subtitleData) {
+		return bytes.TrimPrefix(subtitleData, []byte("\ufeff")), nil
+	}
+
 	e, err := ianaindex.IANA.Encoding("windows-1250")
 	if err != nil || e == nil {
 		return nil, fmt.Errorf("encoding not found: %w", err)
 	}
 
 	r := transform.NewReader(bytes.NewBuffer(subtitleData), e.NewDecoder())
-	utf8, err := io.ReadAll(r)
+	converted, err := io.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read buffer: %w", err)
 	}
 
-	return utf8, err
+	return converted, err
 }
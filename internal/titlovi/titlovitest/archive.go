@@ -0,0 +1,108 @@
+package titlovitest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// File is a file placed in an archive built by ZIP or RAR.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// ZIP builds a ZIP archive containing the files, as Titlovi.com serves most subtitles.
+func ZIP(files ...File) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	for _, file := range files {
+		f, err := w.Create(file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("create %s: %w", file.Name, err)
+		}
+		if _, err := f.Write(file.Data); err != nil {
+			return nil, fmt.Errorf("write %s: %w", file.Name, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close zip: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// RAR builds a RAR 4.x archive containing the files without compression, as Titlovi.com serves
+// some older subtitles.
+func RAR(files ...File) []byte {
+	var buf bytes.Buffer
+
+	// Marker block.
+	buf.Write([]byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x00})
+
+	// Archive header.
+	writeRARBlock(&buf, 0x73, 0x0000, make([]byte, 6))
+
+	for _, file := range files {
+		header := new(bytes.Buffer)
+		_ = binary.Write(header, binary.LittleEndian, uint32(len(file.Data)))        // Packed size.
+		_ = binary.Write(header, binary.LittleEndian, uint32(len(file.Data)))        // Unpacked size.
+		header.WriteByte(0)                                                          // Host OS, MS-DOS.
+		_ = binary.Write(header, binary.LittleEndian, crc32.ChecksumIEEE(file.Data)) // File CRC.
+		_ = binary.Write(header, binary.LittleEndian, uint32(0x21)<<16)              // DOS time, 1980-01-01.
+		header.WriteByte(20)                                                         // Version needed to extract.
+		header.WriteByte(0x30)                                                       // Method, store.
+		_ = binary.Write(header, binary.LittleEndian, uint16(len(file.Name)))        // Name size.
+		_ = binary.Write(header, binary.LittleEndian, uint32(0x20))                  // Attributes, archive.
+		header.WriteString(file.Name)
+
+		// File headers always carry the long block flag, as they are followed by the data.
+		writeRARBlock(&buf, 0x74, 0x8000, header.Bytes())
+		buf.Write(file.Data)
+	}
+
+	// End of archive block.
+	writeRARBlock(&buf, 0x7b, 0x4000, nil)
+
+	return buf.Bytes()
+}
+
+// writeRARBlock writes a RAR 4.x block header with the body that follows the common fields.
+func writeRARBlock(buf *bytes.Buffer, blockType byte, flags uint16, body []byte) {
+	header := new(bytes.Buffer)
+	header.WriteByte(blockType)
+	_ = binary.Write(header, binary.LittleEndian, flags)
+	_ = binary.Write(header, binary.LittleEndian, uint16(7+len(body)))
+	header.Write(body)
+
+	// The header CRC is the lower half of the CRC32 of everything after it.
+	_ = binary.Write(buf, binary.LittleEndian, uint16(crc32.ChecksumIEEE(header.Bytes())))
+	buf.Write(header.Bytes())
+}
+
+// Encode encodes UTF-8 text into the charset with the IANA name, e.g. "windows-1250" or "ISO-8859-2",
+// to build subtitles the way they are commonly uploaded to Titlovi.com.
+func Encode(text string, charset string) ([]byte, error) {
+	e, err := ianaindex.IANA.Encoding(charset)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("encoding %s not found: %w", charset, err)
+	}
+
+	data, err := e.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	return data, nil
+}
+
+// SRT builds a minimal SubRip subtitle with a single cue containing the text.
+func SRT(text string) string {
+	return fmt.Sprintf("1\r\n00:00:01,000 --> 00:00:04,000\r\n%s\r\n", text)
+}
@@ -0,0 +1,290 @@
+// Package titlovitest provides an in-process fake of Titlovi.com for exercising the addon offline.
+package titlovitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-titlovi/internal/titlovi"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiPath      = "/api/subtitles" // Path the fake API is served under, mirroring Titlovi.com.
+	downloadPath = "/download"      // Path the fake downloads are served under, mirroring Titlovi.com.
+)
+
+// Subtitle is a search result fixture, along with what it is matched against when searching.
+type Subtitle struct {
+	Query   string // The query the subtitle is found by, usually an IMDb ID.
+	Season  string
+	Episode string
+	Data    titlovi.SubtitleData
+}
+
+// Fault is a response injected in place of the regular response of an endpoint.
+type Fault struct {
+	Status     int
+	RetryAfter string // Sent as the Retry-After header if not empty.
+}
+
+// token is a login token handed out by the fake.
+type token struct {
+	userId  int64
+	expires time.Time
+}
+
+// Server is an httptest.Server emulating the /gettoken, /search and /download endpoints of Titlovi.com.
+type Server struct {
+	*httptest.Server
+
+	mtx       sync.Mutex
+	users     map[string]string // Passwords by username.
+	userIds   map[string]int64
+	tokens    map[string]token
+	subtitles []Subtitle
+	payloads  map[string][]byte // Downloads by type and media ID.
+	faults    map[titlovi.Endpoint][]Fault
+	requests  map[titlovi.Endpoint]int
+	tokenTTL  time.Duration
+	latency   time.Duration
+	nextId    int64
+}
+
+// NewServer starts a fake with no users, subtitles or payloads. It must be closed once done.
+func NewServer() *Server {
+	s := &Server{
+		users:    make(map[string]string),
+		userIds:  make(map[string]int64),
+		tokens:   make(map[string]token),
+		payloads: make(map[string][]byte),
+		faults:   make(map[titlovi.Endpoint][]Fault),
+		requests: make(map[titlovi.Endpoint]int),
+		tokenTTL: time.Hour,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+apiPath+"/gettoken", s.handle(titlovi.EndpointLogin, s.loginHandler))
+	mux.HandleFunc("GET "+apiPath+"/search", s.handle(titlovi.EndpointSearch, s.searchHandler))
+	mux.HandleFunc("GET "+downloadPath+"/", s.handle(titlovi.EndpointDownload, s.downloadHandler))
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// APIURL returns the URL to pass to titlovi.WithAPIURL.
+func (s *Server) APIURL() string {
+	return s.URL + apiPath
+}
+
+// DownloadURL returns the URL to pass to titlovi.WithDownloadURL.
+func (s *Server) DownloadURL() string {
+	return s.URL + downloadPath
+}
+
+// ClientOptions returns the options that point a titlovi.Client at the fake.
+func (s *Server) ClientOptions() []titlovi.Option {
+	return []titlovi.Option{
+		titlovi.WithAPIURL(s.APIURL()),
+		titlovi.WithDownloadURL(s.DownloadURL()),
+	}
+}
+
+// AddUser registers a user that can log in with the password.
+func (s *Server) AddUser(username, password string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.nextId++
+	s.users[username] = password
+	s.userIds[username] = s.nextId
+}
+
+// AddSubtitle adds a search result fixture.
+func (s *Server) AddSubtitle(sub Subtitle) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.subtitles = append(s.subtitles, sub)
+}
+
+// SetPayload sets the data served when downloading the subtitle with the type and media ID.
+//
+// Use ZIP and RAR to build archives as served by Titlovi.com.
+func (s *Server) SetPayload(mediaType, mediaId string, payload []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.payloads[payloadKey(mediaType, mediaId)] = payload
+}
+
+// SetTokenTTL sets how long tokens handed out from now on stay valid for.
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tokenTTL = ttl
+}
+
+// ExpireTokens invalidates every token handed out so far.
+func (s *Server) ExpireTokens() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	clear(s.tokens)
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.latency = d
+}
+
+// InjectFaults makes the next requests to the endpoint fail with the faults, one per request, in order.
+func (s *Server) InjectFaults(endpoint titlovi.Endpoint, faults ...Fault) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.faults[endpoint] = append(s.faults[endpoint], faults...)
+}
+
+// Requests returns how many requests the endpoint received, including failed ones.
+func (s *Server) Requests(endpoint titlovi.Endpoint) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.requests[endpoint]
+}
+
+// handle wraps the handler of an endpoint with request counting, latency and fault injection.
+func (s *Server) handle(endpoint titlovi.Endpoint, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.Lock()
+		s.requests[endpoint]++
+		latency := s.latency
+
+		var fault *Fault
+		if queue := s.faults[endpoint]; len(queue) > 0 {
+			fault = &queue[0]
+			s.faults[endpoint] = queue[1:]
+		}
+		s.mtx.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if fault != nil {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			http.Error(w, http.StatusText(fault.Status), fault.Status)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// loginHandler emulates /gettoken.
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	password := r.URL.Query().Get("password")
+
+	s.mtx.Lock()
+	expected, ok := s.users[username]
+	if !ok || expected != password {
+		s.mtx.Unlock()
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	s.nextId++
+	tokenStr := fmt.Sprintf("token-%d", s.nextId)
+	t := token{userId: s.userIds[username], expires: time.Now().Add(s.tokenTTL)}
+	s.tokens[tokenStr] = t
+	s.mtx.Unlock()
+
+	writeJSON(w, titlovi.LoginData{
+		Username:       username,
+		UserId:         t.userId,
+		Token:          tokenStr,
+		ExpirationDate: t.expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// searchHandler emulates /search.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t, ok := s.tokens[q.Get("token")]
+	if !ok || time.Now().After(t.expires) || strconv.FormatInt(t.userId, 10) != q.Get("userid") {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	langs := strings.Split(q.Get("lang"), "|")
+
+	results := []titlovi.SubtitleData{}
+	for _, sub := range s.subtitles {
+		if !strings.EqualFold(sub.Query, q.Get("query")) {
+			continue
+		}
+		if season := q.Get("season"); season != "" && sub.Season != season {
+			continue
+		}
+		if episode := q.Get("episode"); episode != "" && sub.Episode != episode {
+			continue
+		}
+		if q.Get("lang") != "" && !slices.Contains(langs, sub.Data.Lang) {
+			continue
+		}
+		results = append(results, sub.Data)
+	}
+
+	writeJSON(w, titlovi.SubtitleDataResponse{Subtitles: results})
+}
+
+// downloadHandler emulates /download.
+func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mtx.Lock()
+	payload, ok := s.payloads[payloadKey(q.Get("type"), q.Get("mediaid"))]
+	s.mtx.Unlock()
+
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(payload)
+}
+
+// payloadKey returns the key a download payload is stored under.
+func payloadKey(mediaType, mediaId string) string {
+	return mediaType + "-" + mediaId
+}
+
+// writeJSON responds with the value encoded as JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -15,11 +15,19 @@ type LoginRequest struct {
 type SubtitleData struct {
 	Id    int64  `json:"Id"`
 	Title string `json:"Title"`
+	Year  int64  `json:"Year"`
 	Link  string `json:"Link"`
 	Lang  string `json:"Lang"`
 	Type  int64  `json:"Type"`
 }
 
+// Types of media subtitles are for, as in SubtitleData.Type.
+const (
+	TypeMovie       int64 = 1
+	TypeSeries      int64 = 2
+	TypeDocumentary int64 = 3
+)
+
 type SubtitleDataResponse struct {
 	Subtitles []SubtitleData `json:"SubtitleResults"`
 }
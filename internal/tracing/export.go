@@ -0,0 +1,244 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const scopeName = "go-titlovi/internal/tracing"
+
+// The types below are the OTLP/JSON encoding of ExportTraceServiceRequest, as accepted by OTLP/HTTP receivers.
+
+// ExportRequest is the body of an OTLP/HTTP trace export.
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   OTLPResource `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPResource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type OTLPSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              SpanKind    `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []KeyValue  `json:"attributes,omitempty"`
+	Events            []OTLPEvent `json:"events,omitempty"`
+	Status            OTLPStatus  `json:"status"`
+}
+
+type OTLPEvent struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes,omitempty"`
+}
+
+type OTLPStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds exactly one of its fields. Integers are strings, as the OTLP/JSON encoding of int64 requires.
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// String returns the value formatted as text, whatever its type.
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// NewExportRequest encodes the spans recorded by the resource.
+func NewExportRequest(resource Resource, spans []*Span) ExportRequest {
+	resourceAttrs := []KeyValue{keyValue("service.name", resource.ServiceName)}
+	if resource.ServiceVersion != "" {
+		resourceAttrs = append(resourceAttrs, keyValue("service.version", resource.ServiceVersion))
+	}
+
+	encoded := make([]OTLPSpan, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, encodeSpan(s))
+	}
+
+	return ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   OTLPResource{Attributes: resourceAttrs},
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: scopeName}, Spans: encoded}},
+	}}}
+}
+
+// encodeSpan encodes a finished span.
+func encodeSpan(s *Span) OTLPSpan {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	span := OTLPSpan{
+		TraceID:           s.sc.TraceID.String(),
+		SpanID:            s.sc.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Attributes:        keyValues(s.attributes),
+		Status:            OTLPStatus{Code: s.statusCode, Message: s.statusMessage},
+	}
+	if s.parent.IsValid() {
+		span.ParentSpanID = s.parent.String()
+	}
+	for _, e := range s.events {
+		span.Events = append(span.Events, OTLPEvent{TimeUnixNano: unixNano(e.Time), Name: e.Name, Attributes: keyValues(e.Attributes)})
+	}
+
+	return span
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func keyValues(attrs []Attribute) []KeyValue {
+	kvs := make([]KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, keyValue(a.Key, a.Value))
+	}
+	return kvs
+}
+
+// keyValue encodes an attribute, keeping the type of strings, booleans and numbers.
+func keyValue(key string, value any) KeyValue {
+	var v AnyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case uint64:
+		s := strconv.FormatUint(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return KeyValue{Key: key, Value: v}
+}
+
+// WriterExporter writes every batch of spans as a line of OTLP/JSON.
+type WriterExporter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// NewWriterExporter creates a WriterExporter writing to w, which is usually standard output.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// Export writes the spans.
+func (e *WriterExporter) Export(ctx context.Context, resource Resource, spans []*Span) error {
+	line, err := json.Marshal(NewExportRequest(resource, spans))
+	if err != nil {
+		return fmt.Errorf("marshal spans: %w", err)
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if _, err := e.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write spans: %w", err)
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OTLP/HTTP receiver, such as an OpenTelemetry Collector, as JSON.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter creates an OTLPExporter sending spans to the receiver at the endpoint, to which the
+// /v1/traces path is appended unless the endpoint already ends with it.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	return &OTLPExporter{url: url, client: &http.Client{Timeout: exportTimeout}}
+}
+
+// Export sends the spans.
+func (e *OTLPExporter) Export(ctx context.Context, resource Resource, spans []*Span) error {
+	body, err := json.Marshal(NewExportRequest(resource, spans))
+	if err != nil {
+		return fmt.Errorf("marshal spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send spans: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("send spans: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxQueueSize   = 2048
+	maxBatchSize   = 512
+	exportInterval = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, resource Resource, spans []*Span) error
+}
+
+// Resource describes the service spans are recorded by.
+type Resource struct {
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Provider samples finished spans and exports them in batches.
+type Provider struct {
+	resource Resource
+	exporter Exporter
+	ratio    float64 // Fraction of new traces that are sampled, between 0 and 1.
+
+	queue    chan *Span
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+var provider atomic.Pointer[Provider]
+
+// noopProvider is used until SetProvider is called, and samples nothing.
+var noopProvider = &Provider{}
+
+// globalProvider returns the provider spans are started with.
+func globalProvider() *Provider {
+	if p := provider.Load(); p != nil {
+		return p
+	}
+	return noopProvider
+}
+
+// NewProvider creates a Provider sampling the ratio of new traces and exporting them with the exporter.
+// Traces continued from another service follow its sampling decision.
+func NewProvider(resource Resource, exporter Exporter, ratio float64) *Provider {
+	p := &Provider{
+		resource: resource,
+		exporter: exporter,
+		ratio:    ratio,
+		queue:    make(chan *Span, maxQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go p.run()
+	return p
+}
+
+// SetProvider makes the provider the one spans are started with.
+func SetProvider(p *Provider) {
+	provider.Store(p)
+}
+
+// sample decides whether a new trace is sampled. The decision is derived from the trace ID, so that
+// every service sampling by ratio makes the same one.
+func (p *Provider) sample(id TraceID) bool {
+	if p.exporter == nil || p.ratio <= 0 {
+		return false
+	}
+	if p.ratio >= 1 {
+		return true
+	}
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(p.ratio*(1<<63))
+}
+
+// enqueue queues the span for export, dropping it if the queue is full.
+func (p *Provider) enqueue(s *Span) {
+	if p.queue == nil {
+		return
+	}
+
+	select {
+	case p.queue <- s:
+	default:
+		slog.Debug("enqueue: span queue full, dropping span", "span", s.name)
+	}
+}
+
+// run exports queued spans in batches, whenever a batch is full or the interval has passed.
+func (p *Provider) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		if err := p.exporter.Export(ctx, p.resource, batch); err != nil {
+			slog.Warn("run: failed to export spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-p.flush:
+			for drained := false; !drained; {
+				select {
+				case s := <-p.queue:
+					batch = append(batch, s)
+					if len(batch) >= maxBatchSize {
+						export()
+					}
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(flushed)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// ForceFlush exports every queued span, waiting until it is done or the context is done.
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	if p.queue == nil {
+		return nil
+	}
+
+	flushed := make(chan struct{})
+	select {
+	case p.flush <- flushed:
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports every queued span and stops the provider. Spans ended afterwards are dropped.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p.queue == nil {
+		return nil
+	}
+
+	err := p.ForceFlush(ctx)
+	p.stopOnce.Do(func() { close(p.done) })
+	return err
+}
@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship of a span to the other spans of a trace, as OTLP defines it.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a finished span, as OTLP defines it.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key-value pair describing a span or event.
+type Attribute struct {
+	Key   string
+	Value any // A string, bool, int, int64, float64 or anything formatted with fmt.
+}
+
+// Event is something that happened at a point in time during a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// Span is a single timed operation within a trace. A nil Span can be used and does nothing.
+type Span struct {
+	provider *Provider
+	sc       SpanContext
+	parent   SpanID
+
+	mtx           sync.Mutex
+	name          string
+	kind          SpanKind
+	start         time.Time
+	end           time.Time
+	attributes    []Attribute
+	events        []Event
+	statusCode    StatusCode
+	statusMessage string
+	ended         bool
+}
+
+// Start starts a span as a child of the span in the context, or of the remote parent extracted into it, and
+// returns a context carrying the new span. The span must be ended with End.
+//
+// Attributes are given as alternating keys and values.
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...any) (context.Context, *Span) {
+	p := globalProvider()
+	parent := SpanContextFromContext(ctx)
+
+	span := &Span{
+		provider:   p,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: pairs(attrs),
+	}
+
+	if parent.IsValid() {
+		// Spans follow the sampling decision of their parent, so that traces are recorded whole.
+		span.sc = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled && p.exporter != nil}
+		span.parent = parent.SpanID
+	} else {
+		span.sc = SpanContext{TraceID: newTraceID()}
+		span.sc.Sampled = p.sample(span.sc.TraceID)
+	}
+	span.sc.SpanID = newSpanID()
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SpanContext returns the span context of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes adds attributes given as alternating keys and values.
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.attributes = append(s.attributes, pairs(attrs)...)
+}
+
+// AddEvent records an event with attributes given as alternating keys and values.
+func (s *Span) AddEvent(name string, attrs ...any) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.events = append(s.events, Event{Name: name, Time: time.Now(), Attributes: pairs(attrs)})
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.statusCode = code
+	s.statusMessage = message
+}
+
+// RecordError records the error as an exception event and marks the span as failed. Nil errors are ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.AddEvent("exception", "exception.type", fmt.Sprintf("%T", err), "exception.message", err.Error())
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes the span and queues it for export if it is sampled. Calling End more than once does nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	if s.ended {
+		s.mtx.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mtx.Unlock()
+
+	if s.sc.Sampled {
+		s.provider.enqueue(s)
+	}
+}
+
+// pairs converts alternating keys and values into attributes, ignoring a trailing key without a value.
+func pairs(kv []any) []Attribute {
+	attrs := make([]Attribute, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, Attribute{Key: fmt.Sprint(kv[i]), Value: kv[i+1]})
+	}
+	return attrs
+}
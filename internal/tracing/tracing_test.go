@@ -0,0 +1,202 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"go-titlovi/internal/tracing"
+	"go-titlovi/internal/tracing/tracingtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useCollector makes spans be exported to a new collector, sampling the ratio of new traces.
+func useCollector(t *testing.T, ratio float64) (*tracingtest.Collector, *tracing.Provider) {
+	t.Helper()
+
+	collector := tracingtest.NewCollector()
+	t.Cleanup(collector.Close)
+
+	provider := tracing.NewProvider(tracing.Resource{ServiceName: "test", ServiceVersion: "1.0.0"}, tracing.NewOTLPExporter(collector.Endpoint()), ratio)
+	tracing.SetProvider(provider)
+	t.Cleanup(func() {
+		tracing.SetProvider(nil)
+		_ = provider.Shutdown(context.Background())
+	})
+	return collector, provider
+}
+
+// flush exports every span ended so far.
+func flush(t *testing.T, provider *tracing.Provider) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := provider.ForceFlush(ctx); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	collector, provider := useCollector(t, 1)
+
+	ctx, parent := tracing.Start(context.Background(), "parent", tracing.KindServer, "http.route", "/manifest.json")
+	_, child := tracing.Start(ctx, "child", tracing.KindClient, "attempt", 2, "cached", false)
+	child.RecordError(errors.New("upstream failed"))
+	child.End()
+	parent.End()
+	flush(t, provider)
+
+	spans := collector.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(spans))
+	}
+
+	got := collector.SpansNamed("child")
+	if len(got) != 1 {
+		t.Fatalf("collector received %d child spans, want 1", len(got))
+	}
+	c := got[0]
+	if c.TraceID != parent.SpanContext().TraceID.String() || c.ParentSpanID != parent.SpanContext().SpanID.String() {
+		t.Errorf("child span is in trace %s under %s, want trace %s under %s",
+			c.TraceID, c.ParentSpanID, parent.SpanContext().TraceID, parent.SpanContext().SpanID)
+	}
+	if c.Kind != tracing.KindClient || c.Status.Code != tracing.StatusError || c.Status.Message != "upstream failed" {
+		t.Errorf("child span kind %d and status %+v, want a failed client span", c.Kind, c.Status)
+	}
+	if len(c.Events) != 1 || c.Events[0].Name != "exception" {
+		t.Errorf("child span events = %+v, want an exception", c.Events)
+	}
+	for key, want := range map[string]string{"attempt": "2", "cached": "false"} {
+		if value, ok := c.Attribute(key); value != want {
+			t.Errorf("child span attribute %s = %q (present %t), want %q", key, value, ok, want)
+		}
+	}
+	if c.Resource["service.name"] != "test" || c.Resource["service.version"] != "1.0.0" {
+		t.Errorf("resource = %v, want the service name and version", c.Resource)
+	}
+
+	p := collector.SpansNamed("parent")[0]
+	if p.ParentSpanID != "" {
+		t.Errorf("parent span has parent %s, want none", p.ParentSpanID)
+	}
+	if route, _ := p.Attribute("http.route"); route != "/manifest.json" {
+		t.Errorf("parent span route = %q, want /manifest.json", route)
+	}
+}
+
+func TestOTLPExportRejected(t *testing.T) {
+	useCollector(t, 1)
+	_, span := tracing.Start(context.Background(), "span", tracing.KindInternal)
+	span.End()
+
+	rejecting := tracingtest.NewCollector()
+	defer rejecting.Close()
+	rejecting.SetStatus(http.StatusServiceUnavailable)
+
+	// The exporter is called directly, since the provider only logs export errors.
+	exporter := tracing.NewOTLPExporter(rejecting.Endpoint() + "/v1/traces/")
+	if err := exporter.Export(context.Background(), tracing.Resource{ServiceName: "test"}, []*tracing.Span{span}); err == nil {
+		t.Error("Export to a collector responding 503 succeeded, want an error")
+	}
+	if spans := rejecting.Spans(); len(spans) != 0 {
+		t.Errorf("collector recorded %d rejected spans, want 0", len(spans))
+	}
+}
+
+func TestSampling(t *testing.T) {
+	collector, provider := useCollector(t, 0)
+
+	_, span := tracing.Start(context.Background(), "unsampled", tracing.KindInternal)
+	span.End()
+
+	// Traces continued from another service follow its decision rather than the ratio.
+	header := http.Header{}
+	header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, remote := tracing.Start(tracing.Extract(context.Background(), header), "sampled", tracing.KindServer)
+	remote.End()
+
+	header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, unsampled := tracing.Start(tracing.Extract(context.Background(), header), "unsampled", tracing.KindServer)
+	unsampled.End()
+	flush(t, provider)
+
+	if spans := collector.SpansNamed("unsampled"); len(spans) != 0 {
+		t.Errorf("collector received %d unsampled spans, want 0", len(spans))
+	}
+	spans := collector.SpansNamed("sampled")
+	if len(spans) != 1 {
+		t.Fatalf("collector received %d spans of the sampled remote trace, want 1", len(spans))
+	}
+	if s := spans[0]; s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("span continuing the remote trace is in trace %s under %s, want the remote parent", s.TraceID, s.ParentSpanID)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	collector, provider := useCollector(t, 1)
+
+	// The downstream service continues the trace of the traceparent header it receives.
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "downstream", tracing.KindServer)
+		span.End()
+	}))
+	defer downstream.Close()
+
+	ctx, span := tracing.Start(context.Background(), "upstream", tracing.KindClient)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downstream.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing.Inject(ctx, req.Header)
+	if got, want := req.Header.Get(tracing.TraceparentHeader), span.SpanContext().Traceparent(); got != want {
+		t.Errorf("injected traceparent %q, want %q", got, want)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	span.End()
+	flush(t, provider)
+
+	spans := collector.SpansNamed("downstream")
+	if len(spans) != 1 {
+		t.Fatalf("collector received %d downstream spans, want 1", len(spans))
+	}
+	if s := spans[0]; s.TraceID != span.SpanContext().TraceID.String() || s.ParentSpanID != span.SpanContext().SpanID.String() {
+		t.Errorf("downstream span is in trace %s under %s, want it under the upstream span", s.TraceID, s.ParentSpanID)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		sc, err := tracing.ParseTraceparent(tt.header)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseTraceparent(%q) error %v, want valid %t", tt.header, err, tt.valid)
+			continue
+		}
+		if tt.valid && (sc.Sampled != tt.sampled || !sc.Remote) {
+			t.Errorf("ParseTraceparent(%q) = %+v, want sampled %t and remote", tt.header, sc, tt.sampled)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package tracingtest provides an in-process stand-in for an OpenTelemetry Collector receiving OTLP/HTTP JSON.
+package tracingtest
+
+import (
+	"encoding/json"
+	"go-titlovi/internal/tracing"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Span is a span received by the collector, along with the resource it was recorded by.
+type Span struct {
+	tracing.OTLPSpan
+	Resource map[string]string
+}
+
+// Attribute returns the value of the attribute with the key formatted as text, and whether the span has it.
+func (s Span) Attribute(key string) (string, bool) {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value.String(), true
+		}
+	}
+	return "", false
+}
+
+// Collector is an httptest.Server accepting trace exports on /v1/traces and recording the spans in them.
+type Collector struct {
+	*httptest.Server
+
+	mtx    sync.Mutex
+	spans  []Span
+	status int
+}
+
+// NewCollector starts a collector accepting every export. It must be closed once done.
+func NewCollector() *Collector {
+	c := &Collector{status: http.StatusOK}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/traces", c.tracesHandler)
+
+	c.Server = httptest.NewServer(mux)
+	return c
+}
+
+// Endpoint returns the endpoint to pass to tracing.NewOTLPExporter.
+func (c *Collector) Endpoint() string {
+	return c.URL
+}
+
+// SetStatus makes the collector respond to exports with the status, without recording their spans unless
+// it is a success.
+func (c *Collector) SetStatus(status int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.status = status
+}
+
+// Spans returns every span received so far, in the order received.
+func (c *Collector) Spans() []Span {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return append([]Span(nil), c.spans...)
+}
+
+// SpansNamed returns every span received so far with the name.
+func (c *Collector) SpansNamed(name string) []Span {
+	var spans []Span
+	for _, s := range c.Spans() {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Reset forgets every span received so far.
+func (c *Collector) Reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.spans = nil
+}
+
+func (c *Collector) tracesHandler(w http.ResponseWriter, r *http.Request) {
+	var req tracing.ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.status < 200 || c.status >= 300 {
+		w.WriteHeader(c.status)
+		return
+	}
+
+	for _, rs := range req.ResourceSpans {
+		resource := make(map[string]string)
+		for _, kv := range rs.Resource.Attributes {
+			resource[kv.Key] = kv.Value.String()
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				c.spans = append(c.spans, Span{OTLPSpan: s, Resource: resource})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
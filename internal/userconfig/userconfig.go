@@ -0,0 +1,237 @@
+// Package userconfig encodes the configuration users choose on the configuration page into addon URLs, and
+// decodes and validates it, migrating configurations encoded by older versions of the addon.
+//
+// Configurations are encoded as "<version>.<payload>", where the payload is the base64url encoding of the JSON
+// of that version. A "z" after the version marks payloads compressed with DEFLATE, which is done whenever it makes
+// them shorter. Version 1 configurations predate this and are the base64url encoding of plain JSON.
+package userconfig
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/languages"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Config is the configuration a user chose on the configuration page.
+type Config struct {
+	Username string
+	Password string
+
+	Languages      []string // The languages to show subtitles in, in order of preference. All languages the addon searches in if empty.
+	Script         string   // The script to prefer for languages written in several, "Latn" or "Cyrl". No preference if empty.
+	OnlyIMDb       bool     // Whether to hide subtitles found by title rather than by IMDb ID.
+	MaxPerLanguage int      // How many subtitles to show per language at most. No limit if zero.
+	Ranking        string   // How to order subtitles, one of the Ranking constants. RankingLanguage if empty.
+	Locale         string   // The locale to describe the addon in, e.g. "bs". Negotiated from the request if empty.
+}
+
+// Rankings users can order subtitles by.
+const (
+	RankingLanguage = "language" // By preferred language, keeping the order of Titlovi.com within a language.
+	RankingNewest   = "newest"   // By preferred language, newest first within a language.
+	RankingTitlovi  = "titlovi"  // In the order of Titlovi.com.
+)
+
+// Rankings are the rankings users can choose from, in the order they are offered.
+var Rankings = []string{RankingLanguage, RankingNewest, RankingTitlovi}
+
+// Version is the version configurations are encoded in.
+const Version = 3
+
+// MaxPerLanguage is the highest number of subtitles per language users can limit results to.
+const MaxPerLanguage = 100
+
+// maxDecodedSize limits how large a decompressed payload can be, so that small URLs cannot expand into large
+// allocations.
+const maxDecodedSize = 16 << 10
+
+// decoders decode the payloads of every version, migrating them to the current one.
+var decoders = map[int]func(payload []byte) (Config, error){
+	1: func(payload []byte) (Config, error) {
+		var c v1
+		if err := unmarshal(payload, &c); err != nil {
+			return Config{}, err
+		}
+		return c.migrate().migrate().config(), nil
+	},
+	2: func(payload []byte) (Config, error) {
+		var c v2
+		if err := unmarshal(payload, &c); err != nil {
+			return Config{}, err
+		}
+		return c.migrate().config(), nil
+	},
+	3: func(payload []byte) (Config, error) {
+		var c v3
+		if err := unmarshal(payload, &c); err != nil {
+			return Config{}, err
+		}
+		return c.config(), nil
+	},
+}
+
+// v1 is the configuration as encoded before versioning, with the long keys of its fields.
+type v1 struct {
+	Username       string   `json:"username"`
+	Password       string   `json:"password"`
+	Languages      []string `json:"languages"`
+	Script         string   `json:"script"`
+	OnlyIMDb       bool     `json:"onlyImdb"`
+	MaxPerLanguage int      `json:"maxPerLanguage"`
+	Ranking        string   `json:"ranking"`
+}
+
+func (c v1) migrate() v2 {
+	return v2(c)
+}
+
+// v2 is the configuration with short keys, leaving out defaults.
+type v2 struct {
+	Username       string   `json:"u"`
+	Password       string   `json:"p"`
+	Languages      []string `json:"l,omitempty"`
+	Script         string   `json:"s,omitempty"`
+	OnlyIMDb       bool     `json:"i,omitempty"`
+	MaxPerLanguage int      `json:"m,omitempty"`
+	Ranking        string   `json:"r,omitempty"`
+}
+
+func (c v2) migrate() v3 {
+	return v3{
+		Username:       c.Username,
+		Password:       c.Password,
+		Languages:      c.Languages,
+		Script:         c.Script,
+		OnlyIMDb:       c.OnlyIMDb,
+		MaxPerLanguage: c.MaxPerLanguage,
+		Ranking:        c.Ranking,
+	}
+}
+
+// v3 adds the locale the user configured the addon in.
+type v3 struct {
+	Username       string   `json:"u"`
+	Password       string   `json:"p"`
+	Languages      []string `json:"l,omitempty"`
+	Script         string   `json:"s,omitempty"`
+	OnlyIMDb       bool     `json:"i,omitempty"`
+	MaxPerLanguage int      `json:"m,omitempty"`
+	Ranking        string   `json:"r,omitempty"`
+	Locale         string   `json:"lc,omitempty"`
+}
+
+func (c v3) config() Config {
+	return Config(c)
+}
+
+// Encode encodes the configuration in the current version.
+func Encode(c Config) (string, error) {
+	data, err := json.Marshal(v3(c))
+	if err != nil {
+		return "", fmt.Errorf("marshal user config: %w", err)
+	}
+
+	prefix := strconv.Itoa(Version)
+	var compressed bytes.Buffer
+	zw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	if _, err := zw.Write(data); err == nil && zw.Close() == nil && compressed.Len() < len(data) {
+		prefix, data = prefix+"z", compressed.Bytes()
+	}
+
+	return prefix + "." + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Decode decodes a configuration encoded in any version and validates it.
+func Decode(s string) (*Config, error) {
+	c, err := DecodeUnvalidated(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DecodeUnvalidated decodes a configuration encoded in any version without validating it, so that an invalid
+// configuration can still be shown to the user to fix.
+func DecodeUnvalidated(s string) (*Config, error) {
+	version, compressed, payload := 1, false, s
+	if prefix, rest, ok := strings.Cut(s, "."); ok {
+		prefix, compressed = strings.CutSuffix(prefix, "z")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("decode user config: malformed version %q", prefix)
+		}
+		version, payload = v, rest
+	}
+
+	decode, ok := decoders[version]
+	if !ok {
+		return nil, fmt.Errorf("decode user config: unsupported version %d", version)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode user config: %w", err)
+	}
+	if compressed {
+		if data, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), maxDecodedSize+1)); err != nil {
+			return nil, fmt.Errorf("decompress user config: %w", err)
+		}
+	}
+	if len(data) > maxDecodedSize {
+		return nil, errors.New("decode user config: too large")
+	}
+
+	c, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode user config version %d: %w", version, err)
+	}
+	return &c, nil
+}
+
+// Validate checks every field of the configuration and returns an error describing every problem found.
+func Validate(c *Config) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(strings.TrimSpace(c.Username) != "", "username must not be empty")
+	check(strings.TrimSpace(c.Password) != "", "password must not be empty")
+	for _, name := range c.Languages {
+		_, known := languages.Default.Lookup(name)
+		check(known, "languages entry %q is not a language Titlovi.com has subtitles in", name)
+	}
+	check(slices.Contains([]string{"", string(languages.ScriptLatin), string(languages.ScriptCyrillic)}, c.Script), "script %q must be Latn, Cyrl or empty", c.Script)
+	check(c.MaxPerLanguage >= 0 && c.MaxPerLanguage <= MaxPerLanguage, "maxPerLanguage %d must be between 0 and %d", c.MaxPerLanguage, MaxPerLanguage)
+	check(c.Ranking == "" || slices.Contains(Rankings, c.Ranking), "ranking %q must be one of %s", c.Ranking, strings.Join(Rankings, ", "))
+	check(c.Locale == "" || i18n.Supported(c.Locale), "locale %q is not supported", c.Locale)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid user config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// unmarshal decodes JSON, rejecting fields the version does not have.
+func unmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	return nil
+}
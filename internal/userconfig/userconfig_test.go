@@ -0,0 +1,181 @@
+package userconfig_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"go-titlovi/internal/userconfig"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Configurations as encoded by every version of the addon, which must keep decoding after later changes.
+const (
+	// {"username":"user","password":"secret","languages":["Srpski","Hrvatski"],"script":"Cyrl","onlyImdb":true,"maxPerLanguage":5,"ranking":"newest"}
+	fixtureV1 = "eyJ1c2VybmFtZSI6InVzZXIiLCJwYXNzd29yZCI6InNlY3JldCIsImxhbmd1YWdlcyI6WyJTcnBza2kiLCJIcnZhdHNraSJdLCJzY3JpcHQiOiJDeXJsIiwib25seUltZGIiOnRydWUsIm1heFBlckxhbmd1YWdlIjo1LCJyYW5raW5nIjoibmV3ZXN0In0"
+	// {"u":"user","p":"secret","l":["Srpski","Hrvatski"],"s":"Cyrl","i":true,"m":5,"r":"newest"}
+	fixtureV2 = "2.eyJ1IjoidXNlciIsInAiOiJzZWNyZXQiLCJsIjpbIlNycHNraSIsIkhydmF0c2tpIl0sInMiOiJDeXJsIiwiaSI6dHJ1ZSwibSI6NSwiciI6Im5ld2VzdCJ9"
+	// {"u":"user","p":"secret","l":["Srpski","Hrvatski","Bosanski","Makedonski","Slovenski","English"],"r":"titlovi"}, compressed.
+	fixtureV2Compressed = "2z.LMexCsJADIDhd_nne4IbBcHFqaM4HBr0aGhKkt4ivnuHdvu-HxuVLcQprFRCXi5JQakPJl9j7hRuPloevFi05eC9zfK2M5PakNPX5aM9vjwLTiV7qo3Ofx8A"
+	// {"u":"user","p":"secret","l":["Srpski"],"lc":"sr"}
+	fixtureV3 = "3.eyJ1IjoidXNlciIsInAiOiJzZWNyZXQiLCJsIjpbIlNycHNraSJdLCJsYyI6InNyIn0"
+	// {"u":"user","p":"secret","l":["Srpski","Hrvatski","Bosanski","Makedonski","Slovenski","English"],"r":"titlovi"}, compressed.
+	fixtureV3Compressed = "3z.q1YqVbJSKi1OLVLSUSoAMotTk4tSS4CcHCWraKXgooLi7Ewgz6OoLLEEwnTKL07MgzB9E7NTU_KhnOCc_LJUKNs1Lz0nszhDKVZHqQhoaElmCVAyU6kWAA"
+)
+
+func TestDecodeLegacyVersions(t *testing.T) {
+	migrated := userconfig.Config{
+		Username:       "user",
+		Password:       "secret",
+		Languages:      []string{"Srpski", "Hrvatski"},
+		Script:         "Cyrl",
+		OnlyIMDb:       true,
+		MaxPerLanguage: 5,
+		Ranking:        userconfig.RankingNewest,
+	}
+
+	tests := []struct {
+		name string
+		s    string
+		want userconfig.Config
+	}{
+		{"v1", fixtureV1, migrated},
+		{"v2", fixtureV2, migrated},
+		{"v2 compressed", fixtureV2Compressed, userconfig.Config{
+			Username:  "user",
+			Password:  "secret",
+			Languages: []string{"Srpski", "Hrvatski", "Bosanski", "Makedonski", "Slovenski", "English"},
+			Ranking:   userconfig.RankingTitlovi,
+		}},
+		{"v3", fixtureV3, userconfig.Config{Username: "user", Password: "secret", Languages: []string{"Srpski"}, Locale: "sr"}},
+		{"v3 compressed", fixtureV3Compressed, userconfig.Config{
+			Username:  "user",
+			Password:  "secret",
+			Languages: []string{"Srpski", "Hrvatski", "Bosanski", "Makedonski", "Slovenski", "English"},
+			Ranking:   userconfig.RankingTitlovi,
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := userconfig.Decode(tt.s)
+		if err != nil {
+			t.Errorf("Decode %s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Decode %s = %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		config userconfig.Config
+		prefix string // The version the configuration is expected to be encoded in, if either.
+	}{
+		{userconfig.Config{Username: "user", Password: "secret"}, "3."},
+		{userconfig.Config{Username: "user", Password: "secret", Languages: []string{"Cirilica"}, Script: "Cyrl", MaxPerLanguage: 3, Locale: "bs"}, ""},
+		{userconfig.Config{
+			Username:       "user",
+			Password:       "secret",
+			Languages:      []string{"Srpski", "Hrvatski", "Bosanski", "Makedonski", "Slovenski", "English"},
+			OnlyIMDb:       true,
+			MaxPerLanguage: userconfig.MaxPerLanguage,
+			Ranking:        userconfig.RankingNewest,
+		}, "3z."},
+	}
+
+	for _, tt := range tests {
+		s, err := userconfig.Encode(tt.config)
+		if err != nil {
+			t.Fatalf("Encode(%+v): %v", tt.config, err)
+		}
+		if !strings.HasPrefix(s, tt.prefix) {
+			t.Errorf("Encode(%+v) = %q, want it prefixed with %q", tt.config, s, tt.prefix)
+		}
+
+		got, err := userconfig.Decode(s)
+		if err != nil {
+			t.Errorf("Decode(Encode(%+v)): %v", tt.config, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.config) {
+			t.Errorf("Decode(Encode(%+v)) = %+v", tt.config, *got)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	// The payload decompresses into more than 16KB, which small URLs must not be able to expand into.
+	large, err := json.Marshal(map[string]string{"u": "user", "p": strings.Repeat("x", 16<<10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	if _, err := zw.Write(large); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{"empty", ""},
+		{"malformed version", "x.e30"},
+		{"unsupported version", "4.e30"},
+		{"compressed v1", "z.e30"},
+		{"malformed base64", "3.!!!"},
+		{"padded base64", "3.e30="},
+		{"malformed JSON", "3." + encode([]byte(`{"u":`))},
+		{"malformed DEFLATE", "3z." + encode([]byte("not deflate"))},
+		{"unknown field", "3." + encode([]byte(`{"u":"user","p":"secret","x":1}`))},
+		{"field of a later version", "2." + encode([]byte(`{"u":"user","p":"secret","lc":"sr"}`))},
+		{"field of an earlier version", "3." + encode([]byte(`{"username":"user","password":"secret"}`))},
+		{"large", "3." + encode(large)},
+		{"large when decompressed", "3z." + encode(compressed.Bytes())},
+	}
+
+	for _, tt := range tests {
+		if c, err := userconfig.DecodeUnvalidated(tt.s); err == nil {
+			t.Errorf("DecodeUnvalidated %s = %+v, want an error", tt.name, c)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config userconfig.Config
+		valid  bool
+	}{
+		{"minimal", userconfig.Config{Username: "user", Password: "secret"}, true},
+		{"every field", userconfig.Config{Username: "user", Password: "secret", Languages: []string{"srpski"}, Script: "Latn", MaxPerLanguage: 100, Ranking: "titlovi", Locale: "hr"}, true},
+		{"blank username", userconfig.Config{Username: " ", Password: "secret"}, false},
+		{"no password", userconfig.Config{Username: "user"}, false},
+		{"unknown language", userconfig.Config{Username: "user", Password: "secret", Languages: []string{"Klingon"}}, false},
+		{"unknown script", userconfig.Config{Username: "user", Password: "secret", Script: "Grek"}, false},
+		{"negative maximum", userconfig.Config{Username: "user", Password: "secret", MaxPerLanguage: -1}, false},
+		{"maximum too high", userconfig.Config{Username: "user", Password: "secret", MaxPerLanguage: 101}, false},
+		{"unknown ranking", userconfig.Config{Username: "user", Password: "secret", Ranking: "random"}, false},
+		{"unsupported locale", userconfig.Config{Username: "user", Password: "secret", Locale: "de"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := userconfig.Validate(&tt.config); (err == nil) != tt.valid {
+			t.Errorf("Validate %s: got %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+
+	// Every problem is reported at once, so that users can fix them together.
+	err := userconfig.Validate(&userconfig.Config{Script: "Grek"})
+	if err == nil || strings.Count(err.Error(), "\n") != 3 {
+		t.Errorf("Validate with three problems: got %v, want all three", err)
+	}
+}
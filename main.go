@@ -3,13 +3,24 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"go-titlovi/api"
+	"go-titlovi/api/middleware"
 	"go-titlovi/internal/config"
+	"go-titlovi/internal/idmap"
 	"go-titlovi/internal/logger"
+	"go-titlovi/internal/metadata"
+	"go-titlovi/internal/metrics"
+	"go-titlovi/internal/signing"
 	"go-titlovi/internal/titlovi"
+	"go-titlovi/internal/tracing"
+	"go-titlovi/web"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,44 +32,173 @@ var (
 )
 
 func main() {
-	logger.InitLoggers()
-	logger.LogInfo.Printf("main: initializing...")
-	logger.LogInfo.Printf("main: build %s", Build)
+	logger.Init("text")
+	slog.Info("main: initializing...", "build", Build)
 
-	config.InitConfig()
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Printf("Usage of %s:\n%s", os.Args[0], config.Usage())
+		return
+	}
+	if err != nil {
+		logger.Fatal("main: failed to load configuration", "error", err)
+	}
+	logger.Init(cfg.Log.Format)
+	logger.SetLevel(cfg.Log.Level)
+	store := config.NewStore(cfg, os.Args[1:], os.Getenv)
+
+	version := Build
+	if version == "" {
+		version = "dev"
+	}
+
+	traceProvider := newTraceProvider(cfg.Tracing, version)
+	tracing.SetProvider(traceProvider)
 
-	titloviClient := titlovi.NewClient(config.TitloviClientRetryAttempts, config.TitloviClientRetryDelay)
+	titloviClient, err := titlovi.NewClient(
+		cfg.Titlovi,
+		titlovi.WithUserAgent(fmt.Sprintf("stremio-addon-titlovi/%s (+https://github.com/AdivonSlav/stremio-addon-titlovi)", version)),
+	)
+	if err != nil {
+		logger.Fatal("main: failed to create Titlovi.com client", "error", err)
+	}
+	metrics.ObserveLoginTokens(titloviClient.ActiveTokens)
 
 	cacheManager, err := ristretto.NewCache(&ristretto.Config{
-		NumCounters: 1e7,
-		MaxCost:     1 << 28,
-		BufferItems: 64,
+		NumCounters: cfg.Cache.NumCounters,
+		MaxCost:     cfg.Cache.MaxCost,
+		BufferItems: cfg.Cache.BufferItems,
 	})
 	if err != nil {
-		logger.LogFatal.Fatalf("main: failed to initialize cache: %s", err)
+		logger.Fatal("main: failed to initialize cache", "error", err)
+	}
+
+	secret, generated, err := cfg.SigningSecret()
+	if err != nil {
+		logger.Fatal("main: failed to set up signing", "error", err)
+	}
+	if generated {
+		slog.Warn("main: SIGNING_SECRET not supplied, generated a random one; signed URLs will not survive restarts")
 	}
+	signer := signing.NewSigner(secret, cfg.Signing.TTL, cfg.Signing.Window)
 
-	router := api.BuildRouter(titloviClient, cacheManager)
-	server := api.BuildServer(&router)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trustedProxies, err := middleware.ParseTrustedProxies(strings.Join(cfg.Server.TrustedProxies, ","))
+	if err != nil {
+		logger.Fatal("main: failed to parse trusted proxies", "error", err)
+	}
+
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit.CleanupTime, middleware.NewIPResolver(trustedProxies))
+	rateLimiter.StartCleanup(ctx)
+
+	ids, err := idmap.Load(cfg.IDMap.File)
+	if err != nil {
+		logger.Fatal("main: failed to load ID mapping", "error", err)
+	}
+	slog.Info("main: loaded ID mapping", "entries", ids.Len())
+
+	meta, err := metadata.Load(cfg.TitleSearch.MetadataFile)
+	if err != nil {
+		logger.Fatal("main: failed to load metadata", "error", err)
+	}
+	slog.Info("main: loaded metadata", "entries", meta.Len())
+
+	var webDir string
+	if cfg.Server.Development {
+		webDir = cfg.Server.WebDir
+	}
+	assets, err := web.NewAssets(webDir)
+	if err != nil {
+		logger.Fatal("main: failed to load web assets", "error", err)
+	}
+	if assets.Reloads() {
+		slog.Info("main: reading web assets from directory", "dir", webDir)
+	}
+
+	health := api.NewHealth(version, store, cacheManager, titloviClient)
+	router, err := api.BuildRouter(store, titloviClient, cacheManager, signer, rateLimiter, health, ids, meta, assets, Build)
+	if err != nil {
+		logger.Fatal("main: failed to build router", "error", err)
+	}
+	server := api.BuildServer(cfg, &router)
 
 	go func() {
-		logger.LogInfo.Printf("main: listening on port %s", config.Port)
+		slog.Info("main: listening", "port", cfg.Server.Port)
 		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			logger.LogFatal.Fatalf("main: error when trying to serve: %s", err)
+			logger.Fatal("main: error when trying to serve", "error", err)
 		}
 	}()
 
+	var metricsServer *http.Server
+	if cfg.Server.MetricsPort != "" {
+		metricsServer = api.BuildMetricsServer(cfg)
+		go func() {
+			slog.Info("main: serving metrics", "port", cfg.Server.MetricsPort)
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				logger.Fatal("main: error when trying to serve metrics", "error", err)
+			}
+		}()
+	}
+
+	store.OnReload(func(cfg *config.Config) {
+		logger.SetLevel(cfg.Log.Level)
+		titloviClient.Reconfigure(cfg.Titlovi)
+	})
+
 	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, syscall.SIGTERM, syscall.SIGINT)
 
-	<-exit
-	logger.LogInfo.Printf("main: terminating...")
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
-	ctx, release := context.WithTimeout(context.Background(), 10*time.Second)
+	for running := true; running; {
+		select {
+		case <-reload:
+			slog.Info("main: reloading configuration...")
+			if _, err := store.Reload(); err != nil {
+				slog.Error("main: rejected configuration reload", "error", err)
+			}
+		case <-exit:
+			running = false
+		}
+	}
+	slog.Info("main: terminating...")
+
+	// Report not being ready for a while, so that load balancers stop routing requests here first.
+	health.SetShuttingDown()
+	time.Sleep(cfg.Server.DrainDelay)
+
+	shutdownCtx, release := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer release()
 
-	if err := server.Shutdown(ctx); err != nil {
-		logger.LogFatal.Fatalf("main: error when trying to shutdown server: %s", err)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Fatal("main: error when trying to shutdown server", "error", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("main: error when trying to shutdown metrics server", "error", err)
+		}
+	}
+	if err := traceProvider.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("main: failed to export remaining spans", "error", err)
+	}
+	slog.Info("main: terminated")
+}
+
+// newTraceProvider creates the provider spans are exported with, which exports nothing unless an exporter is configured.
+func newTraceProvider(cfg config.TracingConfig, version string) *tracing.Provider {
+	resource := tracing.Resource{ServiceName: cfg.ServiceName, ServiceVersion: version}
+
+	switch cfg.Exporter {
+	case "stdout":
+		slog.Info("main: exporting traces to stdout", "sampleRatio", cfg.SampleRatio)
+		return tracing.NewProvider(resource, tracing.NewWriterExporter(os.Stdout), cfg.SampleRatio)
+	case "otlp":
+		slog.Info("main: exporting traces over OTLP", "endpoint", cfg.Endpoint, "sampleRatio", cfg.SampleRatio)
+		return tracing.NewProvider(resource, tracing.NewOTLPExporter(cfg.Endpoint), cfg.SampleRatio)
+	default:
+		return tracing.NewProvider(resource, nil, 0)
 	}
-	logger.LogInfo.Printf("main: terminated")
 }
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"embed"
+	"fmt"
+	"go-titlovi/internal/i18n"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+)
+
+//go:embed templates static
+var embedded embed.FS
+
+// Assets provides the templates and static files of the web pages, which are embedded into the binary.
+//
+// In development, they can be read from a directory laid out the same way instead. They are then re-read on
+// every use, so that changes show without a restart.
+type Assets struct {
+	fsys      fs.FS
+	reload    bool
+	templates *template.Template
+}
+
+// NewAssets creates Assets with the embedded files, or with the files in dir if it is not empty. The templates
+// are parsed right away, so that broken ones are reported on startup.
+func NewAssets(dir string) (*Assets, error) {
+	a := &Assets{fsys: embedded}
+	if dir != "" {
+		a.fsys, a.reload = os.DirFS(dir), true
+	}
+
+	templates, err := parseTemplates(a.fsys)
+	if err != nil {
+		return nil, err
+	}
+	a.templates = templates
+	return a, nil
+}
+
+// Execute renders the named template with the data.
+func (a *Assets) Execute(w io.Writer, name string, data any) error {
+	templates := a.templates
+	if a.reload {
+		var err error
+		if templates, err = parseTemplates(a.fsys); err != nil {
+			return err
+		}
+	}
+	return templates.ExecuteTemplate(w, name, data)
+}
+
+// Static returns the static files, such as stylesheets, scripts and images.
+func (a *Assets) Static() fs.FS {
+	static, _ := fs.Sub(a.fsys, "static")
+	return static
+}
+
+// Reloads reports whether the files are read from a directory on every use rather than embedded.
+func (a *Assets) Reloads() bool {
+	return a.reload
+}
+
+// funcs are the functions available to templates. Messages are translated with t, e.g. {{ t .Locale "page.title" }}.
+var funcs = template.FuncMap{"t": i18n.T}
+
+// parseTemplates parses every template in the templates directory of the files.
+func parseTemplates(fsys fs.FS) (*template.Template, error) {
+	templates, err := template.New("").Funcs(funcs).ParseFS(fsys, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse templates: %w", err)
+	}
+	return templates, nil
+}
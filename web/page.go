@@ -0,0 +1,68 @@
+package web
+
+import (
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/userconfig"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+// Option is a choice offered on the configuration page.
+type Option struct {
+	Value string
+	Label string // The ID of the message labelling the choice.
+}
+
+// Scripts are the script preferences offered on the configuration page.
+var Scripts = []Option{
+	{"", "script.any"},
+	{string(languages.ScriptLatin), "script.latin"},
+	{string(languages.ScriptCyrillic), "script.cyrillic"},
+}
+
+// Rankings are the orders subtitles can be shown in, as offered on the configuration page.
+var Rankings = []Option{
+	{userconfig.RankingLanguage, "ranking.language"},
+	{userconfig.RankingNewest, "ranking.newest"},
+	{userconfig.RankingTitlovi, "ranking.titlovi"},
+}
+
+// ConfigurePage is what the configuration page is rendered from.
+type ConfigurePage struct {
+	Form        UserConfig
+	Action      string // The path the form is submitted to.
+	Editing     bool   // Whether an existing configuration is edited, in which case the password can be left blank to keep it.
+	TitleSearch bool   // Whether the addon searches by title, so that hiding those results is offered.
+	Scripts     []Option
+	Rankings    []Option
+	Install     *InstallLinks // Set once the configuration was saved.
+	CSRFField   string        // The form field to submit the CSRF token in.
+	CSRFToken   string
+	Locale      string        // The locale the page is shown in.
+	Locales     []i18n.Locale // The locales offered by the locale switcher.
+	LocaleParam string        // The query parameter switching the locale.
+}
+
+// InstallLinks are the ways to install the addon with a configuration.
+type InstallLinks struct {
+	ManifestURL   string       // To paste into Stremio.
+	StremioWebURL string       // Opens the addon in Stremio Web.
+	DeepLink      template.URL // Opens the addon in the Stremio app. Trusted, since templates reject the stremio scheme otherwise.
+	ConfigureURL  string       // Edits the configuration later.
+}
+
+// NewInstallLinks returns the links to install the addon at the public address with the encoded configuration.
+func NewInstallLinks(address, encoded string) *InstallLinks {
+	base := address + "/" + encoded
+	manifestURL := base + "/manifest.json"
+	_, hostPath, _ := strings.Cut(manifestURL, "://")
+
+	return &InstallLinks{
+		ManifestURL:   manifestURL,
+		StremioWebURL: "https://web.stremio.com/#/addons?addon=" + url.QueryEscape(manifestURL),
+		DeepLink:      template.URL("stremio://" + hostPath),
+		ConfigureURL:  base + "/configure",
+	}
+}
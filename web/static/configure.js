@@ -0,0 +1,21 @@
+document.querySelectorAll("#languages .move").forEach(function (button) {
+  button.addEventListener("click", function () {
+    var item = button.closest("li");
+    if (button.dataset.move === "up" && item.previousElementSibling) {
+      item.parentNode.insertBefore(item, item.previousElementSibling);
+    } else if (button.dataset.move === "down" && item.nextElementSibling) {
+      item.parentNode.insertBefore(item.nextElementSibling, item);
+    }
+  });
+});
+
+var copy = document.getElementById("copy-manifest-url");
+if (copy) {
+  copy.addEventListener("click", function () {
+    var input = document.getElementById("manifest-url");
+    input.select();
+    navigator.clipboard.writeText(input.value).then(function () {
+      copy.textContent = copy.dataset.copied;
+    });
+  });
+}
@@ -1,23 +1,154 @@
 package web
 
-import "strings"
+import (
+	"go-titlovi/internal/i18n"
+	"go-titlovi/internal/languages"
+	"go-titlovi/internal/userconfig"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LanguageOption is a language offered on the configuration page.
+type LanguageOption struct {
+	Name        string // The name Titlovi.com knows the language by.
+	DisplayName string
+	Selected    bool
+}
 
 type UserConfig struct {
-	Username string
-	Password string
-	Errors   map[string]string
+	Username       string
+	Password       string
+	Languages      []LanguageOption // The languages the addon searches in, in the order the user prefers them.
+	Script         string           // The preferred script, "Latn", "Cyrl" or empty for no preference.
+	OnlyIMDb       bool
+	MaxPerLanguage string // As entered, empty for no limit.
+	Ranking        string
+	Locale         string            // The locale the form is shown in, which the configuration saves.
+	Errors         map[string]string // Problems with the fields, in the locale.
+}
+
+// NewUserConfig creates the form for editing the configuration, or for a new configuration if c is nil, offering
+// the languages the addon searches in, named in the locale.
+func NewUserConfig(c *userconfig.Config, searched []string, locale string) UserConfig {
+	if c == nil {
+		c = &userconfig.Config{}
+	}
+
+	form := UserConfig{
+		Username: c.Username,
+		Script:   c.Script,
+		OnlyIMDb: c.OnlyIMDb,
+		Ranking:  c.Ranking,
+		Locale:   locale,
+	}
+	if form.Ranking == "" {
+		form.Ranking = userconfig.RankingLanguage
+	}
+	if c.MaxPerLanguage > 0 {
+		form.MaxPerLanguage = strconv.Itoa(c.MaxPerLanguage)
+	}
+
+	selected := c.Languages
+	if len(selected) == 0 {
+		selected = searched
+	}
+	form.Languages = languageOptions(languages.Default.Canonical(selected), searched, locale)
+	return form
+}
+
+// ParseUserConfig reads the configuration from the values of a submitted form shown in the locale, offering the
+// languages the addon searches in. Selected languages are submitted in the order the user arranged them.
+func ParseUserConfig(form url.Values, searched []string, locale string) UserConfig {
+	return UserConfig{
+		Username:       form.Get("username"),
+		Password:       form.Get("password"),
+		Languages:      languageOptions(languages.Default.Canonical(form["languages"]), searched, locale),
+		Script:         form.Get("script"),
+		OnlyIMDb:       form.Get("onlyImdb") != "",
+		MaxPerLanguage: strings.TrimSpace(form.Get("maxPerLanguage")),
+		Ranking:        form.Get("ranking"),
+		Locale:         locale,
+	}
+}
+
+// languageOptions returns the searched languages as options named in the locale, the selected ones first in their
+// order.
+func languageOptions(selected, searched []string, locale string) []LanguageOption {
+	searched = languages.Default.Canonical(searched)
+
+	var options []LanguageOption
+	add := func(name string, isSelected bool) {
+		if !slices.Contains(searched, name) || slices.ContainsFunc(options, func(o LanguageOption) bool { return o.Name == name }) {
+			return
+		}
+		display := languages.Default.Resolve(name).DisplayName(locale)
+		options = append(options, LanguageOption{Name: name, DisplayName: display, Selected: isSelected})
+	}
+
+	for _, name := range selected {
+		add(name, true)
+	}
+	for _, name := range searched {
+		add(name, false)
+	}
+	return options
 }
 
+// Validate checks every field, recording the problems found in Errors in the locale of the form.
 func (c *UserConfig) Validate() bool {
 	c.Errors = make(map[string]string)
 
 	if strings.TrimSpace(c.Username) == "" {
-		c.Errors["Username"] = "You must enter a username"
+		c.Errors["Username"] = i18n.T(c.Locale, "error.username")
 	}
 
 	if strings.TrimSpace(c.Password) == "" {
-		c.Errors["Password"] = "You must enter a password"
+		c.Errors["Password"] = i18n.T(c.Locale, "error.password")
+	}
+
+	if !slices.ContainsFunc(c.Languages, func(o LanguageOption) bool { return o.Selected }) {
+		c.Errors["Languages"] = i18n.T(c.Locale, "error.languages")
+	}
+
+	if !slices.Contains([]string{"", string(languages.ScriptLatin), string(languages.ScriptCyrillic)}, c.Script) {
+		c.Errors["Script"] = i18n.T(c.Locale, "error.script")
+	}
+
+	if n, err := strconv.Atoi(c.MaxPerLanguage); c.MaxPerLanguage != "" && (err != nil || n < 0 || n > userconfig.MaxPerLanguage) {
+		c.Errors["MaxPerLanguage"] = i18n.T(c.Locale, "error.maxPerLanguage", userconfig.MaxPerLanguage)
+	}
+
+	if !slices.Contains(userconfig.Rankings, c.Ranking) {
+		c.Errors["Ranking"] = i18n.T(c.Locale, "error.ranking")
 	}
 
 	return len(c.Errors) == 0
 }
+
+// UserConfig returns the configuration to encode into the addon URL. The languages are left out if the user kept
+// the default, so that they follow the languages the addon searches in.
+func (c *UserConfig) UserConfig(searched []string) userconfig.Config {
+	config := userconfig.Config{
+		Username: c.Username,
+		Password: c.Password,
+		Script:   c.Script,
+		OnlyIMDb: c.OnlyIMDb,
+		Locale:   c.Locale,
+	}
+	config.MaxPerLanguage, _ = strconv.Atoi(c.MaxPerLanguage)
+	if c.Ranking != userconfig.RankingLanguage {
+		config.Ranking = c.Ranking
+	}
+
+	for _, o := range c.Languages {
+		if o.Selected {
+			config.Languages = append(config.Languages, o.Name)
+		}
+	}
+	if slices.Equal(config.Languages, languages.Default.Canonical(searched)) {
+		config.Languages = nil
+	}
+	return config
+}